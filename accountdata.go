@@ -0,0 +1,92 @@
+package extractor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// writeAccountData saves account data that isn't part of the public repo,
+// the preferences (including muted words), muted accounts and muted lists,
+// under recordsPath/_account. It only works for the logged-in account, and
+// reports false without doing anything for any other DID.
+func writeAccountData(ctx context.Context, did, recordsPath string) (bool, error) {
+	if session == nil || session.did() != did {
+		return false, nil
+	}
+	dir := filepath.Join(recordsPath, "_account")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return false, err
+	}
+
+	var prefs *bsky.ActorGetPreferences_Output
+	err := session.withClient(ctx, session.host, func(c *xrpc.Client) error {
+		var err error
+		prefs, err = bsky.ActorGetPreferences(ctx, c)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get preferences: %w", err)
+	}
+	if err := writeJSONFile(filepath.Join(dir, "preferences.json"), prefs); err != nil {
+		return false, err
+	}
+
+	var mutes []*bsky.ActorDefs_ProfileView
+	cursor := ""
+	for {
+		var out *bsky.GraphGetMutes_Output
+		err := session.withClient(ctx, session.host, func(c *xrpc.Client) error {
+			var err error
+			out, err = bsky.GraphGetMutes(ctx, c, cursor, 100)
+			return err
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to get mutes: %w", err)
+		}
+		mutes = append(mutes, out.Mutes...)
+		if out.Cursor == nil || *out.Cursor == "" {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	if err := writeJSONFile(filepath.Join(dir, "mutes.json"), mutes); err != nil {
+		return false, err
+	}
+
+	var lists []*bsky.GraphDefs_ListView
+	cursor = ""
+	for {
+		var out *bsky.GraphGetListMutes_Output
+		err := session.withClient(ctx, session.host, func(c *xrpc.Client) error {
+			var err error
+			out, err = bsky.GraphGetListMutes(ctx, c, cursor, 100)
+			return err
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to get muted lists: %w", err)
+		}
+		lists = append(lists, out.Lists...)
+		if out.Cursor == nil || *out.Cursor == "" {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	if err := writeJSONFile(filepath.Join(dir, "list-mutes.json"), lists); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0666)
+}
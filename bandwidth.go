@@ -0,0 +1,97 @@
+package extractor
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// bandwidthLimiter caps the combined rate at which response bodies from
+// PDSes are read, across all workers. A nil *bandwidthLimiter doesn't
+// throttle.
+type bandwidthLimiter struct {
+	lim   *rate.Limiter
+	burst int
+}
+
+// bandwidth enforces -max-bandwidth when it is set.
+var bandwidth *bandwidthLimiter
+
+func newBandwidthLimiter(bytesPerSec int64) *bandwidthLimiter {
+	// a burst of a tenth of a second keeps the rate smooth without
+	// making reads tiny
+	burst := int(min(max(bytesPerSec/10, 4<<10), 1<<20))
+	return &bandwidthLimiter{lim: rate.NewLimiter(rate.Limit(bytesPerSec), burst), burst: burst}
+}
+
+// wrap returns body throttled to the shared rate.
+func (bl *bandwidthLimiter) wrap(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if bl == nil {
+		return body
+	}
+	return &throttledBody{ReadCloser: body, ctx: ctx, bl: bl}
+}
+
+type throttledBody struct {
+	io.ReadCloser
+	ctx context.Context
+	bl  *bandwidthLimiter
+}
+
+func (tb *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > tb.bl.burst {
+		p = p[:tb.bl.burst]
+	}
+	n, err := tb.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := tb.bl.lim.WaitN(tb.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// parseByteSize parses a size such as "10MB", "512KiB" or "1500000". The
+// units K, M and G (optionally followed by B) are powers of 1000, and KiB,
+// MiB and GiB powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	t := strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(t), strings.ToUpper(u.suffix)) {
+			t = strings.TrimSpace(t[:len(t)-len(u.suffix)])
+			mult = u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(t, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// byteSizeVar registers a flag taking a size in bytes (see parseByteSize).
+func byteSizeVar(fs *flag.FlagSet, p *int64, name, usage string) {
+	fs.Func(name, usage, func(s string) error {
+		n, err := parseByteSize(s)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	})
+}
@@ -0,0 +1,34 @@
+package extractor
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"1500000", 1500000},
+		{"0", 0},
+		{"10MB", 10_000_000},
+		{"10mb", 10_000_000},
+		{"10M", 10_000_000},
+		{"512KiB", 512 << 10},
+		{"1.5GiB", 3 << 29},
+		{"2 GB", 2_000_000_000},
+		{"100B", 100},
+		{" 4K ", 4000},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if err != nil {
+			t.Errorf("parseByteSize(%q): %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-1MB", "ten", "10TB"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded, want an error", in)
+		}
+	}
+}
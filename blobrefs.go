@@ -0,0 +1,104 @@
+package extractor
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// blobRefIndex maps each blob CID to the URIs of the records referencing
+// it. A nil blobRefIndex collects nothing.
+type blobRefIndex map[string][]string
+
+// add records every blob referenced anywhere in a record's value.
+func (bi blobRefIndex) add(uri string, value any) error {
+	if bi == nil {
+		return nil
+	}
+	generic, err := toGeneric(value)
+	if err != nil {
+		return err
+	}
+	for _, c := range blobRefCIDs(generic) {
+		uris := bi[c]
+		if len(uris) == 0 || uris[len(uris)-1] != uri {
+			bi[c] = append(uris, uri)
+		}
+	}
+	return nil
+}
+
+// blobRefCIDs finds the blob references in a generic JSON value: objects
+// with "$type": "blob" and a ref link, as well as the legacy form with a
+// plain "cid" and "mimeType".
+func blobRefCIDs(v any) []string {
+	var out []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if v["$type"] == "blob" {
+				if ref, ok := v["ref"].(map[string]any); ok {
+					if link, ok := ref["$link"].(string); ok {
+						out = append(out, link)
+						return
+					}
+				}
+			}
+			if c, ok := v["cid"].(string); ok {
+				if _, ok := v["mimeType"].(string); ok {
+					out = append(out, c)
+					return
+				}
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(v)
+	return out
+}
+
+// blobRefLine is one line of _blob_refs.ndjson.
+type blobRefLine struct {
+	CID  string   `json:"cid"`
+	URIs []string `json:"uris"`
+}
+
+// write saves the index as _blob_refs.ndjson, one blob per line in CID
+// order.
+func (bi blobRefIndex) write(recordsPath string, bufSize int) error {
+	cids := make([]string, 0, len(bi))
+	for c := range bi {
+		cids = append(cids, c)
+	}
+	sort.Strings(cids)
+
+	os.MkdirAll(recordsPath, os.ModePerm)
+	f, err := os.Create(filepath.Join(recordsPath, "_blob_refs.ndjson"))
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(f, bufSize)
+	enc := json.NewEncoder(bw)
+	for _, c := range cids {
+		uris := bi[c]
+		sort.Strings(uris)
+		if err := enc.Encode(blobRefLine{CID: c, URIs: uris}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
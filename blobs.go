@@ -0,0 +1,155 @@
+package extractor
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// headBlobSize asks the PDS for a blob's size without downloading it. It
+// returns -1 when the size isn't known, for instance because the PDS doesn't
+// answer HEAD requests or omits Content-Length.
+func headBlobSize(ctx context.Context, host, did, cidStr string) int64 {
+	u := strings.TrimSuffix(host, "/") + "/xrpc/com.atproto.sync.getBlob?" + url.Values{
+		"did": {did},
+		"cid": {cidStr},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return -1
+	}
+	resp, err := pdsClient.Do(req)
+	if err != nil {
+		return -1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1
+	}
+	return resp.ContentLength
+}
+
+// blobSizeAllowed reports whether a blob of the given size passes the
+// -min-blob-bytes and -max-blob-bytes limits. Zero limits are disabled.
+func blobSizeAllowed(size int64, config Config) (ok bool, reason string) {
+	if config.MaxBlobBytes > 0 && size > config.MaxBlobBytes {
+		return false, "skipped-too-large"
+	}
+	if config.MinBlobBytes > 0 && size < config.MinBlobBytes {
+		return false, "skipped-too-small"
+	}
+	return true, ""
+}
+
+// blobFilePath returns where a blob is stored under topDir. With a shard
+// depth of N the blob goes N directories down, each named by the next two
+// hex characters of the CID's hash digest, much like git's object store
+// (e.g. _blob/3f/a2/bafkrei...). Depth 0 keeps the flat layout.
+func blobFilePath(topDir, cidStr string, depth int) string {
+	if depth <= 0 {
+		return filepath.Join(topDir, cidStr)
+	}
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return filepath.Join(topDir, cidStr)
+	}
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return filepath.Join(topDir, cidStr)
+	}
+	digest := hex.EncodeToString(dmh.Digest)
+
+	parts := []string{topDir}
+	for i := 0; i < depth && 2*i+2 <= len(digest); i++ {
+		parts = append(parts, digest[2*i:2*i+2])
+	}
+	parts = append(parts, cidStr)
+	return filepath.Join(parts...)
+}
+
+// shortBlobNameBytes is how many bytes of the hash digest a short blob name
+// encodes: 10 bytes make 16 base32 characters.
+const shortBlobNameBytes = 10
+
+var shortBlobEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// shortBlobName returns a 16-character name for a blob made from the start
+// of its CID's hash digest, or the CID itself if it can't be decoded.
+func shortBlobName(cidStr string) string {
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return cidStr
+	}
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil || len(dmh.Digest) < shortBlobNameBytes {
+		return cidStr
+	}
+	return shortBlobEncoding.EncodeToString(dmh.Digest[:shortBlobNameBytes])
+}
+
+// blobNames picks the file names of a repo's blobs. With -short-blob-names
+// it hands out short names and keeps the short name -> CID mapping in
+// _blob/_index.json; a nil *blobNames names every blob by its full CID.
+type blobNames struct {
+	path   string
+	byName map[string]string
+}
+
+// openBlobNames loads the name index of the blob directory topDir, when
+// short names are enabled.
+func openBlobNames(topDir string, config Config) (*blobNames, error) {
+	if !config.ShortBlobNames {
+		return nil, nil
+	}
+	bn := &blobNames{path: filepath.Join(topDir, "_index.json"), byName: map[string]string{}}
+	b, err := os.ReadFile(bn.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return bn, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &bn.byName); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", bn.path, err)
+	}
+	return bn, nil
+}
+
+// blobPath returns where the blob cidStr is stored under topDir. A short
+// name already taken by another CID falls back to the full CID, so names
+// never collide.
+func (bn *blobNames) blobPath(topDir, cidStr string, depth int) string {
+	path := blobFilePath(topDir, cidStr, depth)
+	if bn == nil {
+		return path
+	}
+	name := shortBlobName(cidStr)
+	if other, ok := bn.byName[name]; ok && other != cidStr {
+		return path
+	}
+	bn.byName[name] = cidStr
+	return filepath.Join(filepath.Dir(path), name)
+}
+
+// save writes the name index.
+func (bn *blobNames) save() error {
+	if bn == nil {
+		return nil
+	}
+	b, err := json.MarshalIndent(bn.byName, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(bn.path, b, 0666)
+}
@@ -0,0 +1,93 @@
+package extractor
+
+import (
+	"hash/maphash"
+	"math"
+	"sync/atomic"
+)
+
+// bloomFilter approximately tracks a set of CIDs in a fixed amount of
+// memory. It never forgets a CID it was given, but may claim to have seen
+// one it wasn't, at about the false-positive rate it was sized for. It is
+// safe for concurrent use; a nil *bloomFilter has seen nothing.
+type bloomFilter struct {
+	bits []atomic.Uint64
+	m    uint64
+	k    int
+	fp   float64
+	seed maphash.Seed
+}
+
+// newBloomFilter sizes a filter for n distinct CIDs at false-positive rate
+// fp, using the usual m = -n·ln(fp)/ln(2)² bits and k = m/n·ln(2) hashes.
+// Past n CIDs the real rate climbs above fp.
+func newBloomFilter(n int, fp float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]atomic.Uint64, (m+63)/64), m: m, k: k, fp: fp, seed: maphash.MakeSeed()}
+}
+
+// positions derives the filter's k bit positions for s from the two
+// 32-bit halves of one 64-bit hash, as h1 + i·h2 (Kirsch and
+// Mitzenmacher).
+func (bf *bloomFilter) positions(s string, fn func(uint64) bool) bool {
+	h := maphash.String(bf.seed, s)
+	h1, h2 := h&math.MaxUint32, h>>32|1
+	for i := 0; i < bf.k; i++ {
+		if !fn((h1 + uint64(i)*h2) % bf.m) {
+			return false
+		}
+	}
+	return true
+}
+
+// has reports whether s may have been added.
+func (bf *bloomFilter) has(s string) bool {
+	if bf == nil {
+		return false
+	}
+	return bf.positions(s, func(p uint64) bool {
+		return bf.bits[p/64].Load()&(1<<(p%64)) != 0
+	})
+}
+
+// add records s.
+func (bf *bloomFilter) add(s string) {
+	if bf == nil {
+		return
+	}
+	bf.positions(s, func(p uint64) bool {
+		w, bit := &bf.bits[p/64], uint64(1)<<(p%64)
+		for {
+			old := w.Load()
+			if old&bit != 0 || w.CompareAndSwap(old, old|bit) {
+				return true
+			}
+		}
+	})
+}
+
+// testAndAdd records s and reports whether it may have been added before.
+// It isn't atomic: two goroutines adding the same new CID at once may both
+// be told it is new, so callers that count need their own lock.
+func (bf *bloomFilter) testAndAdd(s string) bool {
+	seen := bf.has(s)
+	if !seen {
+		bf.add(s)
+	}
+	return seen
+}
+
+// seenBlobs remembers the blobs already in the -blob-store directory, when
+// a -seen-filter is configured. A hit is still confirmed on disk before
+// the blob is skipped.
+var seenBlobs *bloomFilter
@@ -0,0 +1,79 @@
+package extractor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestBloomFilter(t *testing.T) {
+	tests := []struct {
+		n  int
+		fp float64
+	}{
+		{1000, 0.01},
+		{10000, 0.001},
+		{100, 0.1},
+	}
+	for _, tt := range tests {
+		bf := newBloomFilter(tt.n, tt.fp)
+		for i := 0; i < tt.n; i++ {
+			bf.add(fmt.Sprintf("in-%d", i))
+		}
+		for i := 0; i < tt.n; i++ {
+			if !bf.has(fmt.Sprintf("in-%d", i)) {
+				t.Fatalf("n=%d fp=%v: lost in-%d", tt.n, tt.fp, i)
+			}
+		}
+		const probes = 20000
+		hits := 0
+		for i := 0; i < probes; i++ {
+			if bf.has(fmt.Sprintf("out-%d", i)) {
+				hits++
+			}
+		}
+		// comfortably above the sized rate, far below a broken filter's
+		if rate := float64(hits) / probes; rate > 3*tt.fp {
+			t.Errorf("n=%d fp=%v: false-positive rate %v", tt.n, tt.fp, rate)
+		}
+	}
+
+	var none *bloomFilter
+	none.add("x")
+	if none.has("x") || none.testAndAdd("x") {
+		t.Error("a nil *bloomFilter has seen something")
+	}
+}
+
+func TestCopyLocalBlobsSeenButMissing(t *testing.T) {
+	const did = "did:plc:testtesttesttesttesttest"
+	data := []byte("blob bytes")
+	c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir, topDir := t.TempDir(), t.TempDir()
+	src := localBlobPath(dataDir, did, c.String())
+	os.MkdirAll(filepath.Dir(src), os.ModePerm)
+	if err := os.WriteFile(src, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// the filter claims the blob is stored, but it isn't
+	old := seenBlobs
+	defer func() { seenBlobs = old }()
+	seenBlobs = newBloomFilter(10, 0.01)
+	seenBlobs.add(c.String())
+
+	if _, err := copyLocalBlobs(did, topDir, nil, Config{PDSDataDir: dataDir}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(topDir, c.String()))
+	if err != nil || string(got) != string(data) {
+		t.Errorf("blob after a filter hit = %q, %v; want it copied", got, err)
+	}
+}
@@ -0,0 +1,77 @@
+package extractor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBatchRecords is how many records a bolt sink commits per transaction.
+const boltBatchRecords = 1024
+
+// boltSink writes records/<did>/records.bolt, a bbolt database with a
+// bucket per collection holding each record's JSON keyed by its at:// URI.
+// A repo's earlier database is replaced, as the JSON files would be.
+type boltSink struct {
+	db        *bolt.DB
+	canonical bool
+	batch     []outRecord
+}
+
+func newBoltSink(path string, config Config) (*boltSink, error) {
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	os.Remove(path)
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltSink{db: db, canonical: config.Canonical}, nil
+}
+
+func (bs *boltSink) write(rec outRecord) error {
+	bs.batch = append(bs.batch, rec)
+	if len(bs.batch) >= boltBatchRecords {
+		return bs.flush()
+	}
+	return nil
+}
+
+func (bs *boltSink) flush() error {
+	if len(bs.batch) == 0 {
+		return nil
+	}
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		for _, rec := range bs.batch {
+			b, err := tx.CreateBucketIfNotExists([]byte(rec.Collection))
+			if err != nil {
+				return err
+			}
+			var v []byte
+			if bs.canonical {
+				v, err = canonicalJSON(rec.Value)
+			} else {
+				v, err = json.Marshal(rec.Value)
+			}
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(rec.URI), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	bs.batch = bs.batch[:0]
+	return err
+}
+
+func (bs *boltSink) close() error {
+	err := bs.flush()
+	if cerr := bs.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
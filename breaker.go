@@ -0,0 +1,109 @@
+package extractor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CircuitOpenError is returned for repos skipped because their PDS host has
+// failed too many times in a row.
+type CircuitOpenError struct {
+	Host string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("skipped: circuit open for %s after repeated failures", e.Host)
+}
+
+// circuitBreaker stops sending requests to a PDS host after threshold
+// consecutive failures. With a cooldown, one repo is let through again once
+// it has passed; a success closes the circuit, a failure re-opens it. With
+// no cooldown the host stays broken for the rest of the run. A nil
+// *circuitBreaker never trips.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	hosts     map[string]*hostCircuit
+}
+
+type hostCircuit struct {
+	failures int
+	openedAt time.Time
+	tripped  bool // ever opened during this run, for the report
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, hosts: make(map[string]*hostCircuit)}
+}
+
+// allow returns a *CircuitOpenError if requests to host should be skipped.
+func (cb *circuitBreaker) allow(host string) error {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	hc, ok := cb.hosts[host]
+	if !ok || hc.failures < cb.threshold {
+		return nil
+	}
+	if cb.cooldown > 0 && time.Since(hc.openedAt) >= cb.cooldown {
+		// half-open: let this one through, and hold the rest off for
+		// another cooldown in case it fails too
+		hc.openedAt = time.Now()
+		return nil
+	}
+	return &CircuitOpenError{Host: host}
+}
+
+// record notes the outcome of a request to host.
+func (cb *circuitBreaker) record(host string, err error) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	hc, ok := cb.hosts[host]
+	if !ok {
+		hc = &hostCircuit{}
+		cb.hosts[host] = hc
+	}
+	if err == nil {
+		hc.failures = 0
+		return
+	}
+	hc.failures++
+	if hc.failures == cb.threshold || (hc.failures > cb.threshold && cb.cooldown > 0) {
+		if !hc.tripped {
+			logf("Warning: %s failed %d times in a row, skipping its remaining repos\n", host, hc.failures)
+		}
+		hc.openedAt = time.Now()
+		hc.tripped = true
+	}
+}
+
+// brokenHosts lists every host whose circuit opened during the run.
+func (cb *circuitBreaker) brokenHosts() []string {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var hosts []string
+	for host, hc := range cb.hosts {
+		if hc.tripped {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// breaker enforces -breaker-threshold when it is set.
+var breaker *circuitBreaker
@@ -0,0 +1,77 @@
+package extractor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	fail := errors.New("boom")
+	cb := newCircuitBreaker(2, 0)
+
+	cb.record("a", fail)
+	if err := cb.allow("a"); err != nil {
+		t.Fatalf("allow after one failure = %v, want nil", err)
+	}
+	cb.record("a", nil)
+	cb.record("a", fail)
+	if err := cb.allow("a"); err != nil {
+		t.Fatalf("a success should reset the count, got %v", err)
+	}
+	cb.record("a", fail)
+	var open *CircuitOpenError
+	if err := cb.allow("a"); !errors.As(err, &open) || open.Host != "a" {
+		t.Fatalf("allow after two failures in a row = %v, want a *CircuitOpenError for a", err)
+	}
+	if err := cb.allow("b"); err != nil {
+		t.Errorf("other hosts are unaffected, got %v", err)
+	}
+	if got := cb.brokenHosts(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("brokenHosts = %q, want [a]", got)
+	}
+}
+
+func TestCircuitBreakerCooldown(t *testing.T) {
+	fail := errors.New("boom")
+	cb := newCircuitBreaker(1, 20*time.Millisecond)
+	cb.record("a", fail)
+	if cb.allow("a") == nil {
+		t.Fatal("circuit should be open right after tripping")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := cb.allow("a"); err != nil {
+		t.Fatalf("after the cooldown one repo should get through, got %v", err)
+	}
+	if cb.allow("a") == nil {
+		t.Fatal("only one repo should get through while half-open")
+	}
+
+	// a failure while half-open re-opens the circuit
+	cb.record("a", fail)
+	if cb.allow("a") == nil {
+		t.Fatal("circuit should re-open after a half-open failure")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := cb.allow("a"); err != nil {
+		t.Fatal(err)
+	}
+	cb.record("a", nil)
+	for i := 0; i < 3; i++ {
+		if err := cb.allow("a"); err != nil {
+			t.Fatalf("a success should close the circuit, got %v", err)
+		}
+	}
+}
+
+func TestCircuitBreakerNil(t *testing.T) {
+	var cb *circuitBreaker
+	cb.record("a", errors.New("boom"))
+	if err := cb.allow("a"); err != nil {
+		t.Errorf("nil breaker allow = %v", err)
+	}
+	if hosts := cb.brokenHosts(); hosts != nil {
+		t.Errorf("nil breaker brokenHosts = %q", hosts)
+	}
+}
@@ -0,0 +1,131 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// carCache keeps the last downloaded CAR of each repo as <dir>/<did>/<rev>.car,
+// so that a repo whose PDS still reports the same rev isn't downloaded
+// again. Each CAR has its SHA-256 beside it in <rev>.car.sha256; with verify
+// set a cached CAR is only used once it checks out. A nil *carCache caches
+// nothing.
+type carCache struct {
+	dir    string
+	verify bool
+}
+
+// cars is the -car-cache of the run, if any.
+var cars *carCache
+
+func (cc *carCache) path(did, rev string) string {
+	return filepath.Join(cc.dir, did, rev+".car")
+}
+
+// latest returns the cached CAR of did when the PDS on host reports the
+// same rev as the cached one. Any failure just means a cache miss; a
+// failed rev check is logged.
+func (cc *carCache) latest(ctx context.Context, host, did string) ([]byte, string, bool) {
+	if cc == nil {
+		return nil, "", false
+	}
+	var out *comatproto.SyncGetLatestCommit_Output
+	err := session.withClient(ctx, host, func(c *xrpc.Client) error {
+		var err error
+		out, err = comatproto.SyncGetLatestCommit(ctx, c, did)
+		return err
+	})
+	if err != nil {
+		logf("Warning: can't check the latest commit of %s, downloading it: %v\n", did, err)
+		return nil, "", false
+	}
+	path := cc.path(did, out.Rev)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", false
+	}
+	if cc.verify {
+		if err := checkCachedCar(ctx, path, b, out.Rev); err != nil {
+			logf("Warning: cached CAR of %s at rev %s is damaged, downloading it again: %v\n", did, out.Rev, err)
+			os.Remove(path)
+			os.Remove(path + ".sha256")
+			return nil, "", false
+		}
+	}
+	return b, out.Rev, true
+}
+
+// checkCachedCar checks the cached CAR b, read from path, against the
+// checksum stored beside it. A CAR cached without one must at least parse
+// as a repo whose commit is at rev.
+func checkCachedCar(ctx context.Context, path string, b []byte, rev string) error {
+	line, err := os.ReadFile(path + ".sha256")
+	if err == nil {
+		want, _, _ := strings.Cut(string(line), " ")
+		sum := sha256.Sum256(b)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return fmt.Errorf("SHA-256 is %s, expected %s", got, want)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if got := r.SignedCommit().Rev; got != rev {
+		return fmt.Errorf("commit is at rev %s", got)
+	}
+	return nil
+}
+
+// put caches a downloaded CAR under the rev of its commit, replacing the
+// repo's older entries.
+func (cc *carCache) put(did string, carBytes []byte) error {
+	if cc == nil {
+		return nil
+	}
+	sc, _, _, err := scanCarReader(bytes.NewReader(carBytes))
+	if err != nil {
+		return err
+	}
+	if sc.Rev == "" || strings.ContainsAny(sc.Rev, `/\.`) {
+		return errors.New("commit has no usable rev")
+	}
+	path := cc.path(did, sc.Rev)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, carBytes, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(carBytes)
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), filepath.Base(path))
+	if err := os.WriteFile(path+".sha256", []byte(line), 0666); err != nil {
+		return err
+	}
+	old, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.car*"))
+	for _, p := range old {
+		if p != path && p != path+".sha256" {
+			os.Remove(p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// carFileName returns the on-disk name of a repo's CAR file.
+func carFileName(did string, config Config) string {
+	if config.CompressCars {
+		return did + ".car.gz"
+	}
+	return did + ".car"
+}
+
+// writeCarFile writes CAR bytes to path, gzip-compressing them when the path
+// ends in ".gz".
+func writeCarFile(path string, carBytes []byte) error {
+	if !strings.HasSuffix(path, ".gz") {
+		return os.WriteFile(path, carBytes, 0666)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	if _, err := zw.Write(carBytes); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type carReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (cr *carReader) Close() error {
+	var first error
+	for i := len(cr.closers) - 1; i >= 0; i-- {
+		if err := cr.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// openCar opens a CAR file for reading, transparently decompressing it if
+// it is gzipped. Detection is by content, so a renamed file still works. A
+// path of "-" reads the CAR from stdin.
+func openCar(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return decompressCar(os.Stdin, nil)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return decompressCar(f, f)
+}
+
+// decompressCar wraps r, gunzipping it if needed. closer, if not nil, is
+// closed along with the returned reader.
+func decompressCar(r io.Reader, closer io.Closer) (io.ReadCloser, error) {
+	var closers []io.Closer
+	if closer != nil {
+		closers = append(closers, closer)
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return &carReader{Reader: br, closers: closers}, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+	return &carReader{Reader: zr, closers: append(closers, zr)}, nil
+}
@@ -0,0 +1,67 @@
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// carV2HeaderSize is the size of the fixed CARv2 header that follows the
+// pragma: 16 bytes of characteristics, then the data offset, data size and
+// index offset as little-endian uint64s.
+const carV2HeaderSize = 40
+
+// maxCarHeaderSize bounds the CARv1 header we are willing to read.
+const maxCarHeaderSize = 1 << 20
+
+// carPayload checks the version in a CAR stream's header and returns a
+// reader for its CARv1 data. CARv1 is passed through unchanged; for CARv2
+// the pragma, header and any padding are skipped and the trailing index is
+// cut off, leaving the inner CARv1 payload. Other versions are an error.
+func carPayload(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("not a CAR file: %w", err)
+	}
+	if n == 0 || n > maxCarHeaderSize {
+		return nil, fmt.Errorf("not a CAR file: header length %d", n)
+	}
+	header := make([]byte, n)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("not a CAR file: %w", err)
+	}
+	var h map[string]any
+	if err := cbor.DecodeInto(header, &h); err != nil {
+		return nil, fmt.Errorf("not a CAR file: bad header: %w", err)
+	}
+
+	version, _ := toInt(h["version"])
+	switch version {
+	case 1:
+		prefix := binary.AppendUvarint(nil, n)
+		return io.MultiReader(bytes.NewReader(prefix), bytes.NewReader(header), br), nil
+	case 2:
+		v2 := make([]byte, carV2HeaderSize)
+		if _, err := io.ReadFull(br, v2); err != nil {
+			return nil, fmt.Errorf("truncated CARv2 header: %w", err)
+		}
+		dataOffset := binary.LittleEndian.Uint64(v2[16:24])
+		dataSize := binary.LittleEndian.Uint64(v2[24:32])
+		read := uint64(len(binary.AppendUvarint(nil, n))) + n + carV2HeaderSize
+		if dataOffset < read {
+			return nil, errors.New("invalid CARv2 header: data offset inside header")
+		}
+		if _, err := io.CopyN(io.Discard, br, int64(dataOffset-read)); err != nil {
+			return nil, fmt.Errorf("truncated CARv2 file: %w", err)
+		}
+		return io.LimitReader(br, int64(dataSize)), nil
+	default:
+		return nil, fmt.Errorf("unsupported CAR version %v (only CARv1 and CARv2 can be read)", h["version"])
+	}
+}
@@ -0,0 +1,9 @@
+// Command atproto-car-extractor downloads AT Protocol repos as CAR files
+// and unpacks their records. See the README for its flags and subcommands.
+package main
+
+import extractor "github.com/cpfiffer/atproto-car-extractor"
+
+func main() {
+	extractor.Main()
+}
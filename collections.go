@@ -0,0 +1,207 @@
+package extractor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// defaultCollectionsFile is where -collections-auto writes the discovered
+// collections when it can't ask.
+const defaultCollectionsFile = "collections.txt"
+
+// parseCollections splits a comma-separated -collections value, checking
+// that each entry is an NSID.
+func parseCollections(list string) ([]string, error) {
+	var out []string
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		if _, err := syntax.ParseNSID(c); err != nil {
+			return nil, fmt.Errorf("invalid collection %q: %w", c, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// readCollectionsFile reads a -collections-file: one collection per line,
+// with blank lines and anything after a # ignored.
+func readCollectionsFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		lines = append(lines, line)
+	}
+	return parseCollections(strings.Join(lines, ","))
+}
+
+// collectionCount is a collection found by -collections-auto and how many
+// of the sampled repos have it.
+type collectionCount struct {
+	Collection string
+	Repos      int
+}
+
+// discoverCollections lists the collections of up to sample repos spread
+// evenly over dids with com.atproto.repo.describeRepo, most common first.
+// Repos that can't be described are skipped with a warning.
+func discoverCollections(ctx context.Context, dids []string, sample int, config Config) ([]collectionCount, int) {
+	picked := dids
+	if sample > 0 && len(dids) > sample {
+		picked = make([]string, sample)
+		for i := range picked {
+			picked[i] = dids[i*len(dids)/sample]
+		}
+	}
+
+	counts := map[string]int{}
+	described := 0
+	dir := identity.DefaultDirectory()
+	for _, did := range picked {
+		host, err := resolvePDS(ctx, dir, did, config)
+		if err != nil {
+			logf("Warning: could not resolve %s: %v\n", did, err)
+			continue
+		}
+		var out *comatproto.RepoDescribeRepo_Output
+		err = session.withClient(ctx, host, func(c *xrpc.Client) error {
+			var err error
+			out, err = comatproto.RepoDescribeRepo(ctx, c, did)
+			return err
+		})
+		if err != nil {
+			logf("Warning: could not describe repo %s: %v\n", did, err)
+			continue
+		}
+		described++
+		for _, c := range out.Collections {
+			counts[c]++
+		}
+	}
+
+	found := make([]collectionCount, 0, len(counts))
+	for c, n := range counts {
+		found = append(found, collectionCount{Collection: c, Repos: n})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Repos != found[j].Repos {
+			return found[i].Repos > found[j].Repos
+		}
+		return found[i].Collection < found[j].Collection
+	})
+	return found, described
+}
+
+// promptCollections lists found on out and reads the user's choice from in:
+// numbers and ranges such as "1,3-5", or nothing for all of them.
+func promptCollections(found []collectionCount, sampled int, in io.Reader, out io.Writer) ([]string, error) {
+	fmt.Fprintf(out, "Collections in %d sampled repos:\n", sampled)
+	for i, fc := range found {
+		fmt.Fprintf(out, "%4d  %-40s %d/%d repos\n", i+1, fc.Collection, fc.Repos, sampled)
+	}
+	sc := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "Extract which? (e.g. 1,3-5; empty for all): ")
+		if !sc.Scan() {
+			if err := sc.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		picked, err := parseSelection(sc.Text(), len(found))
+		if err != nil {
+			fmt.Fprintf(out, "%v\n", err)
+			continue
+		}
+		var chosen []string
+		for _, i := range picked {
+			chosen = append(chosen, found[i].Collection)
+		}
+		return chosen, nil
+	}
+}
+
+// parseSelection parses a list of 1-based numbers and ranges out of n into
+// 0-based indexes. An empty selection picks everything.
+func parseSelection(s string, n int) ([]int, error) {
+	var out []int
+	seen := map[int]bool{}
+	add := func(i int) {
+		if !seen[i] {
+			seen[i] = true
+			out = append(out, i)
+		}
+	}
+	if strings.TrimSpace(s) == "" {
+		for i := 0; i < n; i++ {
+			add(i)
+		}
+		return out, nil
+	}
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(lo)
+		b := a
+		if err == nil && isRange {
+			b, err = strconv.Atoi(hi)
+		}
+		if err != nil || a < 1 || b > n || a > b {
+			return nil, fmt.Errorf("%q is not a number or range between 1 and %d", part, n)
+		}
+		for i := a; i <= b; i++ {
+			add(i - 1)
+		}
+	}
+	return out, nil
+}
+
+// writeCollectionsFile saves found in the -collections-file format, with
+// how common each collection is as a comment, for the user to edit.
+func writeCollectionsFile(path string, found []collectionCount, sampled int) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Collections in %d sampled repos. Delete the lines of those not to\n", sampled)
+	fmt.Fprintf(&b, "# extract, then rerun with -collections-file %s.\n", path)
+	for _, fc := range found {
+		fmt.Fprintf(&b, "%s # %d/%d repos\n", fc.Collection, fc.Repos, sampled)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0666)
+}
+
+// chooseCollections runs -collections-auto over dids. On a terminal it
+// asks which collections to extract and returns them; otherwise it writes
+// the collections file and returns nil, as there is nothing to extract yet.
+func chooseCollections(ctx context.Context, dids []string, config Config) ([]string, error) {
+	logf("Discovering collections in up to %d of %d repos\n", config.CollectionsSample, len(dids))
+	found, sampled := discoverCollections(ctx, dids, config.CollectionsSample, config)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no collections found in the %d repos described", sampled)
+	}
+	if isTerminal(os.Stdin) && !config.TUI {
+		return promptCollections(found, sampled, os.Stdin, os.Stderr)
+	}
+	path := config.CollectionsFile
+	if path == "" {
+		path = defaultCollectionsFile
+	}
+	if err := writeCollectionsFile(path, found, sampled); err != nil {
+		return nil, err
+	}
+	logf("Wrote %d collections to %s; edit it and rerun with -collections-file %s\n", len(found), path, path)
+	return nil, nil
+}
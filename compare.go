@@ -0,0 +1,223 @@
+package extractor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// snapshot is the record set of one side of a comparison: record key ->
+// fingerprint. Fingerprints are CIDs when byCID is set, otherwise hashes of
+// the record files.
+type snapshot struct {
+	did     string
+	records map[string]string
+	byCID   bool
+}
+
+// CompareEntry is one changed record in the compare subcommand's output.
+type CompareEntry struct {
+	URI    string `json:"uri"`
+	CID    string `json:"cid,omitempty"`
+	OldCID string `json:"old_cid,omitempty"`
+	NewCID string `json:"new_cid,omitempty"`
+}
+
+// CompareResult is the JSON output of the compare subcommand.
+type CompareResult struct {
+	DID      string         `json:"did"`
+	Added    []CompareEntry `json:"added"`
+	Removed  []CompareEntry `json:"removed"`
+	Modified []CompareEntry `json:"modified"`
+}
+
+// runCompare implements the "compare" subcommand, which diffs the records
+// of two extractions of the same repo. Each side is a CAR file or a records
+// directory written with the default json format.
+func runCompare(args []string) error {
+	var asJSON bool
+	var config Config
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s compare [flags] <old car|dir> <new car|dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&asJSON, "json", false, "print the diff as a JSON object instead of a summary")
+	fs.StringVar(&config.CommitFileName, "commit-file-name", defaultCommitFileName, "commit file name the directories were extracted with")
+	fs.StringVar(&config.RecordSuffix, "record-suffix", defaultRecordSuffix, "record file suffix the directories were extracted with")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	a, err := loadSnapshot(ctx, fs.Arg(0), config)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	b, err := loadSnapshot(ctx, fs.Arg(1), config)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(1), err)
+	}
+	if a.byCID != b.byCID {
+		return errors.New("can't compare a CAR with a directory that has no _cids.json; extract with -record-cids")
+	}
+	if a.did != b.did && a.did != "" && b.did != "" {
+		logf("Warning: comparing different repos %s and %s\n", a.did, b.did)
+	}
+
+	res := diffSnapshots(a, b)
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	for _, e := range res.Added {
+		fmt.Printf("+ %s\n", e.URI)
+	}
+	for _, e := range res.Removed {
+		fmt.Printf("- %s\n", e.URI)
+	}
+	for _, e := range res.Modified {
+		fmt.Printf("~ %s\n", e.URI)
+	}
+	fmt.Printf("%d added, %d removed, %d modified\n", len(res.Added), len(res.Removed), len(res.Modified))
+	return nil
+}
+
+// diffSnapshots lists the records added, removed and modified from a to b,
+// each sorted by URI.
+func diffSnapshots(a, b snapshot) CompareResult {
+	did := b.did
+	if did == "" {
+		did = a.did
+	}
+	res := CompareResult{DID: did, Added: []CompareEntry{}, Removed: []CompareEntry{}, Modified: []CompareEntry{}}
+	cidOf := func(s snapshot, fp string) string {
+		if s.byCID {
+			return fp
+		}
+		return ""
+	}
+	for k, fb := range b.records {
+		uri := "at://" + did + "/" + k
+		fa, ok := a.records[k]
+		switch {
+		case !ok:
+			res.Added = append(res.Added, CompareEntry{URI: uri, CID: cidOf(b, fb)})
+		case fa != fb:
+			res.Modified = append(res.Modified, CompareEntry{URI: uri, OldCID: cidOf(a, fa), NewCID: cidOf(b, fb)})
+		}
+	}
+	for k, fa := range a.records {
+		if _, ok := b.records[k]; !ok {
+			res.Removed = append(res.Removed, CompareEntry{URI: "at://" + did + "/" + k, CID: cidOf(a, fa)})
+		}
+	}
+	for _, list := range [][]CompareEntry{res.Added, res.Removed, res.Modified} {
+		sort.Slice(list, func(i, j int) bool { return list[i].URI < list[j].URI })
+	}
+	return res
+}
+
+// loadSnapshot reads the record set of a CAR file or records directory.
+func loadSnapshot(ctx context.Context, path string, config Config) (snapshot, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	if fi.IsDir() {
+		return loadDirSnapshot(path, config)
+	}
+
+	r, err := readCar(ctx, path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	s := snapshot{did: r.SignedCommit().Did, records: map[string]string{}, byCID: true}
+	err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		s.records[k] = v.String()
+		return nil
+	})
+	return s, err
+}
+
+// loadDirSnapshot reads the record files under a records directory. Record
+// CIDs come from _cids.json when it exists; otherwise records are compared
+// by the SHA-256 of their files, which only finds changes reliably between
+// extractions made with the same options. config gives the commit file
+// and record file names.
+func loadDirSnapshot(dir string, config Config) (snapshot, error) {
+	s := snapshot{did: filepath.Base(dir), records: map[string]string{}}
+	var commit struct {
+		Did string `json:"did"`
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, commitFileName(config))); err == nil && json.Unmarshal(b, &commit) == nil && commit.Did != "" {
+		s.did = commit.Did
+	}
+
+	var cids map[string]string
+	if b, err := os.ReadFile(filepath.Join(dir, "_cids.json")); err == nil {
+		if err := json.Unmarshal(b, &cids); err != nil {
+			return s, fmt.Errorf("reading _cids.json: %w", err)
+		}
+		s.byCID = true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return s, err
+	}
+	var paths map[string]string
+	if b, err := os.ReadFile(filepath.Join(dir, "_paths.json")); err == nil {
+		if err := json.Unmarshal(b, &paths); err != nil {
+			return s, fmt.Errorf("reading _paths.json: %w", err)
+		}
+	}
+
+	collections, err := os.ReadDir(dir)
+	if err != nil {
+		return s, err
+	}
+	suffix := recordSuffix(config)
+	for _, c := range collections {
+		if !c.IsDir() || strings.HasPrefix(c.Name(), "_") {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, c.Name()))
+		if err != nil {
+			return s, err
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), suffix) {
+				continue
+			}
+			rel := c.Name() + "/" + f.Name()
+			k, ok := paths[rel]
+			if !ok {
+				k = strings.TrimSuffix(rel, suffix)
+			}
+			if s.byCID {
+				if v, ok := cids[k]; ok {
+					s.records[k] = v
+				}
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(dir, c.Name(), f.Name()))
+			if err != nil {
+				return s, err
+			}
+			sum := sha256.Sum256(b)
+			s.records[k] = "sha256:" + hex.EncodeToString(sum[:])
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,77 @@
+package extractor
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configEnv names the environment variable that also sets a config file key.
+// Where one is set it wins over the file.
+var configEnv = map[string]string{
+	"webhook":         "WEBHOOK_URL",
+	"auth-identifier": "ATP_AUTH_IDENTIFIER",
+	"download-blobs":  "DOWNLOAD_BLOBS",
+	"dids-file":       "DIDS_FILE",
+}
+
+// applyConfigFile loads a YAML file of flag names and values into fs and
+// config. Flags given on the command line and keys whose environment
+// variable is set are left alone, so the precedence is file < environment
+// < flags. Besides the flags, the file may set dids-file and
+// download-blobs. Unknown keys are an error.
+func applyConfigFile(fs *flag.FlagSet, path string, config *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var unknown []string
+	for key := range values {
+		if key != "dids-file" && key != "download-blobs" && (fs.Lookup(key) == nil || key == "config") {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	for key, v := range values {
+		if set[key] {
+			continue
+		}
+		if env, ok := configEnv[key]; ok && os.Getenv(env) != "" {
+			continue
+		}
+		if _, ok := v.([]any); ok {
+			return fmt.Errorf("config file %s: %s must be a single value", path, key)
+		}
+		if _, ok := v.(map[string]any); ok {
+			return fmt.Errorf("config file %s: %s must be a single value", path, key)
+		}
+		value := fmt.Sprint(v)
+		switch key {
+		case "dids-file":
+			config.DIDsFile = value
+		case "download-blobs":
+			config.DownloadBlobs = value == "true"
+		default:
+			if err := fs.Set(key, value); err != nil {
+				return fmt.Errorf("config file %s: %s: %w", path, key, err)
+			}
+		}
+	}
+	return nil
+}
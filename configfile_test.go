@@ -0,0 +1,77 @@
+package extractor
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfigFilePrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+concurrency: 8
+webhook: https://file.example/hook
+records-dir: from-file
+dids-file: dids.txt
+download-blobs: true
+`)
+	var config Config
+	var concurrency int
+	var webhook, recordsDir string
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.IntVar(&concurrency, "concurrency", 1, "")
+	fs.StringVar(&webhook, "webhook", "", "")
+	fs.StringVar(&recordsDir, "records-dir", "records", "")
+	fs.String("config", "", "")
+	if err := fs.Parse([]string{"-records-dir", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WEBHOOK_URL", "https://env.example/hook")
+
+	if err := applyConfigFile(fs, path, &config); err != nil {
+		t.Fatal(err)
+	}
+	if concurrency != 8 {
+		t.Errorf("concurrency = %d, want 8 from the file", concurrency)
+	}
+	if recordsDir != "from-flag" {
+		t.Errorf("records-dir = %q, want the flag to win over the file", recordsDir)
+	}
+	if webhook != "" {
+		t.Errorf("webhook = %q, want the file value ignored while WEBHOOK_URL is set", webhook)
+	}
+	if config.DIDsFile != "dids.txt" || !config.DownloadBlobs {
+		t.Errorf("dids-file, download-blobs = %q, %v; want dids.txt, true", config.DIDsFile, config.DownloadBlobs)
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"bogus: 1\nconfig: other.yaml\n", "unknown keys"},
+		{"concurrency: [1, 2]\n", "single value"},
+		{"concurrency: lots\n", "concurrency"},
+		{"concurrency: [", "failed to parse"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("concurrency", 1, "")
+		fs.String("config", "", "")
+		err := applyConfigFile(fs, writeConfigFile(t, tt.content), &Config{})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("applyConfigFile(%q) = %v, want an error mentioning %q", tt.content, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultMaxDIDs caps a -follow-depth crawl when -max-dids isn't given.
+const DefaultMaxDIDs = 10000
+
+// refCollections are the collections -follow-depth scans for accounts to
+// crawl: who an account follows, whose posts it reposts and whom its posts
+// mention.
+var refCollections = []string{"app.bsky.graph.follow", "app.bsky.feed.repost", "app.bsky.feed.post"}
+
+// repoRefs returns the DIDs the follows, reposts and mentions of r point
+// at, in first-seen order, leaving out the repo's own DID. Records that
+// can't be decoded are skipped; they were already reported while
+// unpacking.
+func repoRefs(ctx context.Context, r *repo.Repo) ([]string, error) {
+	self := r.SignedCommit().Did
+	seen := map[string]bool{self: true}
+	var refs []string
+	add := func(s string) {
+		if strings.HasPrefix(s, "at://") {
+			u, err := syntax.ParseATURI(s)
+			if err != nil {
+				return
+			}
+			s = u.Authority().String()
+		}
+		if _, err := syntax.ParseDID(s); err != nil || seen[s] {
+			return
+		}
+		seen[s] = true
+		refs = append(refs, s)
+	}
+
+	for _, collection := range refCollections {
+		err := r.ForEach(ctx, collection, func(k string, v cid.Cid) error {
+			if !strings.HasPrefix(k, collection+"/") {
+				return repo.ErrDoneIterating
+			}
+			blk, err := r.Blockstore().Get(ctx, v)
+			if err != nil {
+				return nil
+			}
+			rec, err := decodeRecord(blk.RawData(), false)
+			if err != nil {
+				return nil
+			}
+			generic, err := toGeneric(rec)
+			if err != nil {
+				return nil
+			}
+			switch collection {
+			case "app.bsky.graph.follow":
+				for _, s := range lookupPath(generic, []string{"subject"}) {
+					if s, ok := s.(string); ok {
+						add(s)
+					}
+				}
+			case "app.bsky.feed.repost":
+				for _, s := range lookupPath(generic, []string{"subject", "uri"}) {
+					if s, ok := s.(string); ok {
+						add(s)
+					}
+				}
+			case "app.bsky.feed.post":
+				for _, s := range lookupPath(generic, []string{"facets[]", "features[]", "did"}) {
+					if s, ok := s.(string); ok {
+						add(s)
+					}
+				}
+			}
+			return nil
+		})
+		// the MST wraps the error that ends the walk early
+		if err != nil && !errors.Is(err, repo.ErrDoneIterating) {
+			return refs, err
+		}
+	}
+	return refs, nil
+}
+
+// crawlFrontier hands out the DIDs of a -follow-depth crawl: the seeds at
+// depth 0, then the accounts each finished repo refers to at one more than
+// its depth, every DID once and no more than maxDIDs in all.
+type crawlFrontier struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  []extractJob
+	seen     map[string]bool
+	inFlight int
+	next     int
+	maxDepth int
+	maxDIDs  int
+	capped   bool
+}
+
+func newCrawlFrontier(seeds []string, maxDepth, maxDIDs int) *crawlFrontier {
+	cf := &crawlFrontier{seen: map[string]bool{}, maxDepth: maxDepth, maxDIDs: maxDIDs}
+	cf.cond = sync.NewCond(&cf.mu)
+	for _, did := range seeds {
+		if !cf.seen[did] {
+			cf.seen[did] = true
+			cf.pending = append(cf.pending, extractJob{did: did})
+		}
+	}
+	return cf
+}
+
+// take returns the next DID to extract, waiting while none is pending but
+// a repo in flight may still add some. It returns ok false once the crawl
+// is over or ctx is done.
+func (cf *crawlFrontier) take(ctx context.Context) (extractJob, bool) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	for len(cf.pending) == 0 && cf.inFlight > 0 && ctx.Err() == nil {
+		cf.cond.Wait()
+	}
+	if len(cf.pending) == 0 || ctx.Err() != nil {
+		return extractJob{}, false
+	}
+	job := cf.pending[0]
+	cf.pending = cf.pending[1:]
+	job.index = cf.next
+	cf.next++
+	cf.inFlight++
+	return job, true
+}
+
+// done adds the accounts a finished repo refers to, unless it was already
+// at the maximum depth.
+func (cf *crawlFrontier) done(job extractJob, res RepoResult) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	cf.inFlight--
+	if res.DID != "" {
+		cf.seen[res.DID] = true
+	}
+	if job.depth < cf.maxDepth {
+		added := 0
+		for _, did := range res.refs {
+			if cf.seen[did] {
+				continue
+			}
+			if len(cf.seen) >= cf.maxDIDs {
+				if !cf.capped {
+					cf.capped = true
+					logf("Warning: the crawl reached -max-dids %d; not following any more accounts\n", cf.maxDIDs)
+				}
+				break
+			}
+			cf.seen[did] = true
+			cf.pending = append(cf.pending, extractJob{did: did, depth: job.depth + 1})
+			added++
+		}
+		if added > 0 {
+			dash.grow(added)
+			logf("Following %d accounts referenced by %s (depth %d)\n", added, res.DID, job.depth+1)
+		}
+	}
+	cf.cond.Broadcast()
+}
+
+// wake ends the waits in take once ctx is done.
+func (cf *crawlFrontier) wake() {
+	cf.mu.Lock()
+	cf.cond.Broadcast()
+	cf.mu.Unlock()
+}
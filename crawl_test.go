@@ -0,0 +1,65 @@
+package extractor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCrawlFrontier(t *testing.T) {
+	ctx := context.Background()
+	cf := newCrawlFrontier([]string{"did:plc:a", "did:plc:b", "did:plc:a"}, 1, 4)
+
+	take := func(wantDID string, wantDepth int) extractJob {
+		t.Helper()
+		job, ok := cf.take(ctx)
+		if !ok || job.did != wantDID || job.depth != wantDepth {
+			t.Fatalf("take = %+v, %v; want %s at depth %d", job, ok, wantDID, wantDepth)
+		}
+		return job
+	}
+	a := take("did:plc:a", 0)
+	b := take("did:plc:b", 0)
+	if a.index != 0 || b.index != 1 {
+		t.Errorf("indexes = %d, %d; want 0, 1", a.index, b.index)
+	}
+
+	// b is already known; only c and d fit under the cap of 4
+	cf.done(a, RepoResult{DID: "did:plc:a", refs: []string{"did:plc:b", "did:plc:c", "did:plc:d", "did:plc:e"}})
+	cf.done(b, RepoResult{DID: "did:plc:b", refs: []string{"did:plc:f"}})
+	c := take("did:plc:c", 1)
+	d := take("did:plc:d", 1)
+
+	// repos at the maximum depth aren't followed
+	cf.done(c, RepoResult{DID: "did:plc:c", refs: []string{"did:plc:g"}})
+	cf.done(d, RepoResult{DID: "did:plc:d"})
+	if job, ok := cf.take(ctx); ok {
+		t.Errorf("take after the crawl ended = %+v, want none", job)
+	}
+}
+
+func TestCrawlFrontierWaits(t *testing.T) {
+	cf := newCrawlFrontier([]string{"did:plc:a"}, 2, 10)
+	a, _ := cf.take(context.Background())
+
+	got := make(chan extractJob)
+	go func() {
+		job, _ := cf.take(context.Background())
+		got <- job
+	}()
+	cf.done(a, RepoResult{DID: "did:plc:a", refs: []string{"did:plc:b"}})
+	if job := <-got; job.did != "did:plc:b" || job.depth != 1 {
+		t.Errorf("waiting take = %+v, want did:plc:b at depth 1", job)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		_, ok := cf.take(ctx)
+		done <- ok
+	}()
+	cancel()
+	cf.wake()
+	if <-done {
+		t.Error("take returned a job after ctx was cancelled")
+	}
+}
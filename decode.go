@@ -0,0 +1,23 @@
+package extractor
+
+import (
+	"errors"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+// decodeRecord decodes a record block. With typed set, records of a
+// registered lexicon decode into their indigo Go type, so the JSON keeps
+// that type's field order and omitempty rules; records of any other $type
+// fall back to a generic data-model map. Without it every record is
+// decoded generically, with CIDs as {"$link"} and bytes as {"$bytes"}.
+func decodeRecord(raw []byte, typed bool) (any, error) {
+	if typed {
+		rec, err := lexutil.CborDecodeValue(raw)
+		if !errors.Is(err, lexutil.ErrUnrecognizedType) {
+			return rec, err
+		}
+	}
+	return data.UnmarshalCBOR(raw)
+}
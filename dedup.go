@@ -0,0 +1,124 @@
+package extractor
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// DedupStats summarises how often CIDs repeat within one kind of object.
+type DedupStats struct {
+	Total       int   `json:"total"`
+	Unique      int   `json:"unique"`
+	Duplicates  int   `json:"duplicates"`
+	TotalBytes  int64 `json:"total_bytes"`
+	UniqueBytes int64 `json:"unique_bytes"`
+	BytesSaved  int64 `json:"bytes_saved"`
+}
+
+// DedupReport is the content of the dedup report file.
+type DedupReport struct {
+	Repos int `json:"repos"`
+	// Approximate is set when CIDs were tracked with a -seen-filter, so
+	// some unique CIDs may have been counted as duplicates.
+	Approximate       bool       `json:"approximate,omitempty"`
+	FalsePositiveRate float64    `json:"false_positive_rate,omitempty"`
+	Records           DedupStats `json:"records"`
+	Blobs             DedupStats `json:"blobs"`
+}
+
+// dedupTracker counts record and blob CIDs seen across all processed repos.
+// A nil *dedupTracker ignores everything.
+type dedupTracker struct {
+	mu      sync.Mutex
+	records cidSet
+	blobs   cidSet
+	report  DedupReport
+}
+
+// cidSet is the set of CIDs a dedupTracker has seen: exact, or a bloom
+// filter when a map of every CID would be too big.
+type cidSet interface {
+	testAndAdd(c string) bool
+}
+
+type exactSet map[string]struct{}
+
+func (s exactSet) testAndAdd(c string) bool {
+	if _, ok := s[c]; ok {
+		return true
+	}
+	s[c] = struct{}{}
+	return false
+}
+
+// newDedupTracker tracks CIDs exactly, or in bloom filters sized for n
+// distinct CIDs each at false-positive rate fp when n > 0.
+func newDedupTracker(n int, fp float64) *dedupTracker {
+	if n > 0 {
+		return &dedupTracker{
+			records: newBloomFilter(n, fp),
+			blobs:   newBloomFilter(n, fp),
+			report:  DedupReport{Approximate: true, FalsePositiveRate: fp},
+		}
+	}
+	return &dedupTracker{
+		records: exactSet{},
+		blobs:   exactSet{},
+	}
+}
+
+func (dt *dedupTracker) addRepo() {
+	if dt == nil {
+		return
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.report.Repos++
+}
+
+func (dt *dedupTracker) addRecord(c string, size int64) {
+	if dt == nil {
+		return
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	countCID(dt.records, &dt.report.Records, c, size)
+}
+
+func (dt *dedupTracker) addBlob(c string, size int64) {
+	if dt == nil {
+		return
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	countCID(dt.blobs, &dt.report.Blobs, c, size)
+}
+
+func countCID(seen cidSet, st *DedupStats, c string, size int64) {
+	st.Total++
+	st.TotalBytes += size
+	if seen.testAndAdd(c) {
+		st.Duplicates++
+		st.BytesSaved += size
+		return
+	}
+	st.Unique++
+	st.UniqueBytes += size
+}
+
+func (dt *dedupTracker) writeReport(path string) error {
+	if dt == nil {
+		return nil
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	b, err := json.MarshalIndent(dt.report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0666)
+}
+
+// dedup tracks CID repetition when -dedup-report is given.
+var dedup *dedupTracker
@@ -0,0 +1,124 @@
+package extractor
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// didLineFilter selects lines of the DIDs file by -did-filter and
+// -did-method. A nil *didLineFilter keeps every line.
+type didLineFilter struct {
+	re     *regexp.Regexp
+	method string
+}
+
+// newDIDLineFilter compiles the -did-filter pattern. It returns nil when
+// neither option is set.
+func newDIDLineFilter(pattern, method string) (*didLineFilter, error) {
+	if pattern == "" && method == "" {
+		return nil, nil
+	}
+	f := &didLineFilter{method: strings.TrimPrefix(method, "did:")}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -did-filter: %w", err)
+		}
+		f.re = re
+	}
+	return f, nil
+}
+
+// keep reports whether line passes both the regex and the method. Only
+// DIDs, and at:// URIs with a DID authority, have a method; handles never
+// match -did-method.
+func (f *didLineFilter) keep(line string) bool {
+	if f == nil {
+		return true
+	}
+	if f.re != nil && !f.re.MatchString(line) {
+		return false
+	}
+	if f.method == "" {
+		return true
+	}
+	target := line
+	if strings.HasPrefix(line, "at://") {
+		if u, err := syntax.ParseATURI(line); err == nil {
+			target = u.Authority().String()
+		}
+	}
+	did, err := syntax.ParseDID(target)
+	return err == nil && did.Method() == f.method
+}
+
+// apply returns the lines that pass the filter.
+func (f *didLineFilter) apply(lines []string) []string {
+	if f == nil {
+		return lines
+	}
+	var kept []string
+	for _, line := range lines {
+		if f.keep(line) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// didListVar defines a repeatable flag collecting DIDs (or handles) into p.
+// A value may also list several, separated by commas, as a config file
+// has to.
+func didListVar(fs *flag.FlagSet, p *[]string, name, usage string) {
+	fs.Func(name, usage, func(s string) error {
+		for _, did := range strings.Split(s, ",") {
+			if did = strings.TrimSpace(did); did != "" {
+				*p = append(*p, did)
+			}
+		}
+		return nil
+	})
+}
+
+// selectDIDs applies -only-did and -skip-did to the loaded DIDs, logging
+// each DID they exclude. An -only-did entry missing from the list is
+// logged too, since it was probably meant to be there.
+func selectDIDs(dids, only, skip []string) []string {
+	if len(only) == 0 && len(skip) == 0 {
+		return dids
+	}
+	onlySet := make(map[string]bool, len(only))
+	for _, did := range only {
+		onlySet[did] = true
+	}
+	skipSet := make(map[string]bool, len(skip))
+	for _, did := range skip {
+		skipSet[did] = true
+	}
+
+	var kept []string
+	found := map[string]bool{}
+	for _, did := range dids {
+		switch {
+		case skipSet[did]:
+			logf("Skipping %s (-skip-did)\n", did)
+		case len(onlySet) > 0 && !onlySet[did]:
+		default:
+			found[did] = true
+			kept = append(kept, did)
+		}
+	}
+	if len(onlySet) > 0 {
+		for _, did := range only {
+			if !found[did] && !skipSet[did] {
+				logf("Warning: -only-did %s is not in the DIDs list\n", did)
+			}
+		}
+		logf("Only processing %d of %d DIDs (-only-did): %s\n", len(kept), len(dids), strings.Join(kept, ", "))
+	}
+	return kept
+}
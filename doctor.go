@@ -0,0 +1,158 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// doctorTimeout bounds each network check of the doctor subcommand.
+const doctorTimeout = 15 * time.Second
+
+// doctorCheck is one line of the doctor checklist. run returns nil on
+// success; hint says what to try when it fails.
+type doctorCheck struct {
+	name string
+	hint string
+	run  func(ctx context.Context) error
+}
+
+// runDoctor implements the "doctor" subcommand, which checks connectivity
+// and configuration before a real run and prints a pass/fail checklist.
+func runDoctor(args []string) error {
+	var handle, relay string
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s doctor [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&handle, "handle", "bsky.app", "a known handle to test resolution with")
+	fs.StringVar(&relay, "relay", "https://bsky.network", "relay to test reaching")
+	fs.Parse(args)
+
+	checks := []doctorCheck{
+		{
+			name: "PLC directory " + identity.DefaultPLCURL + " is reachable",
+			hint: "check your network, proxy and firewall settings; did:plc accounts can't be resolved without it",
+			run: func(ctx context.Context) error {
+				return httpHealth(ctx, identity.DefaultPLCURL+"/_health")
+			},
+		},
+		{
+			name: "handle " + handle + " resolves",
+			hint: "handles are resolved over DNS TXT records and HTTPS; check your DNS resolver",
+			run: func(ctx context.Context) error {
+				h, err := syntax.ParseHandle(handle)
+				if err != nil {
+					return err
+				}
+				_, err = identity.DefaultDirectory().LookupHandle(ctx, h)
+				return err
+			},
+		},
+		{
+			name: "relay " + relay + " is reachable",
+			hint: "only needed with -pds pointing at the relay; otherwise repos come from each account's PDS",
+			run: func(ctx context.Context) error {
+				return httpHealth(ctx, relay+"/xrpc/_health")
+			},
+		},
+		{
+			name: "output directories (cars, records) are writable",
+			hint: "run from a directory you can write to, or fix its permissions",
+			run: func(ctx context.Context) error {
+				for _, dir := range []string{"cars", "records"} {
+					if err := checkWritable(dir); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+	if id := os.Getenv("ATP_AUTH_IDENTIFIER"); id != "" {
+		checks = append(checks, doctorCheck{
+			name: "credentials for " + id + " are valid",
+			hint: "check ATP_AUTH_IDENTIFIER and ATP_AUTH_PASSWORD; use an app password, not your account password",
+			run: func(ctx context.Context) error {
+				password := os.Getenv("ATP_AUTH_PASSWORD")
+				if password == "" {
+					return errors.New("ATP_AUTH_PASSWORD is not set")
+				}
+				_, err := newAuthSession(ctx, id, password)
+				return err
+			},
+		})
+	}
+
+	failed := 0
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		err := c.run(ctx)
+		cancel()
+		if err == nil {
+			fmt.Printf("[ OK ] %s\n", c.name)
+			continue
+		}
+		failed++
+		fmt.Printf("[FAIL] %s\n       %v\n       hint: %s\n", c.name, err, c.hint)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	fmt.Println("\nAll checks passed.")
+	return nil
+}
+
+// httpHealth GETs url and expects a 2xx answer.
+func httpHealth(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// checkWritable creates and removes a file in dir, or in the nearest
+// existing parent when dir doesn't exist yet, without creating dir.
+func checkWritable(dir string) error {
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,149 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// downloadAttempts is how many times a CAR download is resumed after the
+// connection drops before giving up.
+const downloadAttempts = 5
+
+// errNoProgress marks an attempt that failed without receiving any bytes.
+var errNoProgress = errors.New("no data received")
+
+// fetchRepoCar downloads a repo's CAR from c.Host into partPath, resuming
+// with a Range request from however much partPath already holds whenever a
+// transfer is cut off. Hosts that ignore ranges just send the whole CAR
+// again. It returns the complete CAR bytes and removes partPath.
+func fetchRepoCar(ctx context.Context, c *xrpc.Client, did, partPath string) ([]byte, error) {
+	resumed := false
+	var last error
+	for attempt := 0; attempt < downloadAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * 2 * time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		offset := int64(0)
+		if fi, err := os.Stat(partPath); err == nil {
+			offset = fi.Size()
+		}
+		done, err := fetchCarRange(ctx, c, did, partPath, offset)
+		if err != nil {
+			var xerr *xrpc.Error
+			if errors.As(err, &xerr) {
+				// an answer from the PDS, not a dropped connection
+				return nil, err
+			}
+			last = err
+			logf("Warning: download of %s interrupted (attempt %d): %v\n", did, attempt+1, err)
+			continue
+		}
+		if offset > 0 && !done {
+			resumed = true
+		}
+		b, err := os.ReadFile(partPath)
+		if err != nil {
+			return nil, err
+		}
+		if resumed {
+			// nothing guarantees the repo didn't change between requests,
+			// so make sure the pieces form a valid CAR
+			payload, err := carPayload(bytes.NewReader(b))
+			if err == nil {
+				_, err = repo.ReadRepoFromCar(ctx, payload)
+			}
+			if err != nil {
+				logf("Warning: resumed download of %s is not a valid CAR (%v); starting over\n", did, err)
+				os.Remove(partPath)
+				resumed = false
+				continue
+			}
+		}
+		os.Remove(partPath)
+		return b, nil
+	}
+	return nil, fmt.Errorf("download failed after %d attempts: %w", downloadAttempts, last)
+}
+
+// fetchCarRange makes one getRepo request for the bytes from offset on,
+// appending them to partPath. It reports whether the host ignored the range
+// and sent the whole CAR, which replaces the partial file.
+func fetchCarRange(ctx context.Context, c *xrpc.Client, did, partPath string, offset int64) (bool, error) {
+	u := c.Host + "/xrpc/com.atproto.sync.getRepo?did=" + url.QueryEscape(did)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+	if c.Auth != nil && c.Auth.AccessJwt != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Auth.AccessJwt)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := pdsClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	full := false
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		full = true
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file doesn't fit the current repo; start over
+		os.Remove(partPath)
+		return false, fmt.Errorf("range not satisfiable for %d bytes", offset)
+	default:
+		return false, xrpcHTTPError(resp)
+	}
+
+	f, err := os.OpenFile(partPath, flags, 0666)
+	if err != nil {
+		return false, err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && n == 0 {
+		err = fmt.Errorf("%w: %v", errNoProgress, err)
+	}
+	return full, err
+}
+
+// xrpcHTTPError turns a failed response into an *xrpc.Error, as the xrpc
+// client would, so callers can inspect it the same way.
+func xrpcHTTPError(resp *http.Response) error {
+	var body xrpc.XRPCError
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err := json.Unmarshal(b, &body); err != nil || body.ErrStr == "" {
+		return &xrpc.Error{StatusCode: resp.StatusCode, Wrapped: fmt.Errorf("%s", resp.Status)}
+	}
+	return &xrpc.Error{StatusCode: resp.StatusCode, Wrapped: &body}
+}
@@ -0,0 +1,115 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestFetchCarRange(t *testing.T) {
+	const car = "hello world"
+	tests := []struct {
+		name     string
+		partial  string
+		handler  http.HandlerFunc
+		wantFull bool
+		wantFile string
+		wantErr  bool
+	}{
+		{
+			name: "whole CAR",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					t.Errorf("unexpected Range %q for a fresh download", r.Header.Get("Range"))
+				}
+				w.Write([]byte(car))
+			},
+			wantFull: true,
+			wantFile: car,
+		},
+		{
+			name:    "resumed",
+			partial: "hello",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Range"); got != "bytes=5-" {
+					t.Errorf("Range = %q, want bytes=5-", got)
+				}
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(car[5:]))
+			},
+			wantFile: car,
+		},
+		{
+			name:    "range ignored",
+			partial: "stale",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(car))
+			},
+			wantFull: true,
+			wantFile: car,
+		},
+		{
+			name:    "range not satisfiable",
+			partial: "much too long",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			partPath := filepath.Join(t.TempDir(), "repo.car.part")
+			if tt.partial != "" {
+				if err := os.WriteFile(partPath, []byte(tt.partial), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			full, err := fetchCarRange(context.Background(), &xrpc.Client{Host: srv.URL}, "did:plc:abc", partPath, int64(len(tt.partial)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("fetchCarRange succeeded, want an error")
+				}
+				if _, err := os.Stat(partPath); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("partial file kept after a 416: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if full != tt.wantFull {
+				t.Errorf("full = %v, want %v", full, tt.wantFull)
+			}
+			b, _ := os.ReadFile(partPath)
+			if string(b) != tt.wantFile {
+				t.Errorf("partial file = %q, want %q", b, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestFetchCarRangeXRPCError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"RepoNotFound","message":"Could not find repo"}`))
+	}))
+	defer srv.Close()
+
+	_, err := fetchCarRange(context.Background(), &xrpc.Client{Host: srv.URL}, "did:plc:abc", filepath.Join(t.TempDir(), "part"), 0)
+	var xerr *xrpc.Error
+	if !errors.As(err, &xerr) || xerr.StatusCode != http.StatusBadRequest || !strings.Contains(err.Error(), "RepoNotFound") {
+		t.Errorf("fetchCarRange = %v, want an *xrpc.Error for RepoNotFound", err)
+	}
+}
@@ -0,0 +1,53 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// encodeRecord renders a decoded record as the bytes written to its file.
+// By default that is indented JSON in the record type's field order; with
+// config.Canonical it is canonical JSON instead.
+func encodeRecord(rec any, config Config) ([]byte, error) {
+	if config.Canonical {
+		return canonicalJSON(rec)
+	}
+	return json.MarshalIndent(rec, "", "  ")
+}
+
+// canonicalJSON encodes v with object keys sorted, no insignificant
+// whitespace, no HTML escaping and numbers kept exactly as the original
+// encoding wrote them, so unchanged records always give identical bytes.
+func canonicalJSON(v any) ([]byte, error) {
+	// round-trip through generic values: maps are always encoded with
+	// sorted keys, and json.Number preserves the number text
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// toGeneric converts a decoded record into plain maps, slices and scalars,
+// the shape its JSON encoding has. Numbers are kept as json.Number so no
+// precision is lost.
+func toGeneric(rec any) (any, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
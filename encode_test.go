@@ -0,0 +1,46 @@
+package extractor
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{"sorted keys", map[string]any{"b": 1, "a": map[string]any{"d": true, "c": nil}}, `{"a":{"c":null,"d":true},"b":1}`},
+		{"no HTML escaping", map[string]any{"text": "<a href=\"x\">&</a>"}, `{"text":"<a href=\"x\">&</a>"}`},
+		{"large integers kept exactly", json.RawMessage(`{"n":9007199254740993,"f":1.50}`), `{"f":1.50,"n":9007199254740993}`},
+		{"struct field order ignored", struct {
+			Z string `json:"z"`
+			A []int  `json:"a"`
+		}{"last", []int{2, 1}}, `{"a":[2,1],"z":"last"}`},
+	}
+	for _, tt := range tests {
+		got, err := canonicalJSON(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: canonicalJSON = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalJSONStable(t *testing.T) {
+	a, err := canonicalJSON(json.RawMessage(`{ "x": [1, 2], "a": "b" }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := canonicalJSON(map[string]any{"a": "b", "x": []any{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) {
+		t.Errorf("same value encoded as %s and %s", a, b)
+	}
+}
@@ -0,0 +1,74 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lifecycle event types written to the event stream.
+const (
+	EventRepoStart      = "repo-start"
+	EventCarDownloaded  = "car-downloaded"
+	EventRecordWritten  = "record-written"
+	EventBlobDownloaded = "blob-downloaded"
+	EventRepoDone       = "repo-done"
+	EventRepoError      = "repo-error"
+)
+
+// Event is a single line of the JSON event stream.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	DID    string    `json:"did,omitempty"`
+	Handle string    `json:"handle,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	CID    string    `json:"cid,omitempty"`
+	Bytes  int       `json:"bytes,omitempty"`
+	Empty  bool      `json:"empty,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// eventWriter encodes events as newline-delimited JSON. A nil *eventWriter
+// discards everything, so callers don't need to check whether events are on.
+type eventWriter struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	records bool
+}
+
+func newEventWriter(w io.Writer, records bool) *eventWriter {
+	return &eventWriter{enc: json.NewEncoder(w), records: records}
+}
+
+func (ew *eventWriter) emit(ev Event) {
+	dash.observe(ev)
+	if ew == nil {
+		return
+	}
+	if ev.Type == EventRecordWritten && !ew.records {
+		return
+	}
+	ev.Time = time.Now().UTC()
+
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if err := ew.enc.Encode(ev); err != nil {
+		logf("Warning: failed to write event: %v\n", err)
+	}
+}
+
+// events is the process-wide event stream, set up by newRun when -events is
+// given.
+var events *eventWriter
+
+// logw receives human-readable progress output. It is moved to stderr when
+// the event stream owns stdout.
+var logw io.Writer = os.Stdout
+
+func logf(format string, args ...any) {
+	fmt.Fprintf(logw, format, args...)
+}
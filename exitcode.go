@@ -0,0 +1,58 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes of an extraction run, so scripts can tell outcomes apart.
+const (
+	exitOK          = 0 // every repo succeeded
+	exitPartial     = 1 // some repos failed, or the run failed after extracting
+	exitUsage       = 2 // bad flags or configuration; nothing was extracted
+	exitAllFailed   = 3 // every repo failed
+	exitInterrupted = 4 // stopped by SIGINT or SIGTERM
+)
+
+// exitError carries the exit code for an error returned by run.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// usageError marks err as a configuration problem found before any repo
+// was processed.
+func usageError(err error) error {
+	return &exitError{code: exitUsage, err: err}
+}
+
+// exitCode returns the process exit code for an error returned by run.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	return exitPartial
+}
+
+// outcomeError summarizes a finished run's report as an error carrying the
+// matching exit code, or returns nil if every repo succeeded.
+func outcomeError(rr *RunReport) error {
+	failed := len(rr.Repos) - rr.OK
+	switch {
+	case rr.Interrupted:
+		return &exitError{code: exitInterrupted, err: fmt.Errorf("interrupted after %d of %d repos", len(rr.Repos), rr.Total)}
+	case failed == 0:
+		return nil
+	case rr.OK == 0:
+		return &exitError{code: exitAllFailed, err: fmt.Errorf("all %d repos failed", failed)}
+	default:
+		return &exitError{code: exitPartial, err: fmt.Errorf("%d of %d repos failed", failed, len(rr.Repos))}
+	}
+}
@@ -0,0 +1,55 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{errors.New("boom"), exitPartial},
+		{usageError(errors.New("bad flag")), exitUsage},
+		{fmt.Errorf("wrapped: %w", usageError(errors.New("bad flag"))), exitUsage},
+		{&exitError{code: exitInterrupted, err: errors.New("stopped")}, exitInterrupted},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestOutcomeError(t *testing.T) {
+	report := func(ok, failed int, interrupted bool) *RunReport {
+		rr := &RunReport{Total: ok + failed, Interrupted: interrupted}
+		for i := 0; i < ok; i++ {
+			rr.add(RepoResult{DID: fmt.Sprintf("did:plc:ok%d", i), Status: StatusOK})
+		}
+		for i := 0; i < failed; i++ {
+			rr.add(RepoResult{DID: fmt.Sprintf("did:plc:failed%d", i), Status: StatusError, Error: "boom"})
+		}
+		return rr
+	}
+	tests := []struct {
+		name string
+		rr   *RunReport
+		want int
+	}{
+		{"all ok", report(3, 0, false), exitOK},
+		{"nothing to do", report(0, 0, false), exitOK},
+		{"some failed", report(2, 1, false), exitPartial},
+		{"all failed", report(0, 2, false), exitAllFailed},
+		{"interrupted", report(1, 0, true), exitInterrupted},
+	}
+	for _, tt := range tests {
+		err := outcomeError(tt.rr)
+		if got := exitCode(err); got != tt.want {
+			t.Errorf("%s: exit code %d (%v), want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
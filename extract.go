@@ -0,0 +1,386 @@
+// Package extractor downloads AT Protocol repos as CAR files and unpacks
+// their records (and blobs) to disk. Main is the atproto-car-extractor
+// command; ExtractAll and ExtractCrawl run the same pipeline from Go.
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// ExtractAll processes dids using config.Concurrency workers and sends each
+// RepoResult on the returned channel as soon as that repo finishes. Workers
+// block until their result is received, so a slow consumer applies
+// backpressure. The channel is closed once every DID has been processed, or
+// early if ctx is cancelled. Repos are started in config.Schedule order.
+//
+// The caches, limiters and sessions that config asks for are set up for
+// the run and torn down once it ends, and the -dedup-report is written
+// then. If they can't be set up, every DID fails with that error. Only one
+// run goes at a time in a process; ExtractAll waits for the one before.
+func ExtractAll(ctx context.Context, config Config, dids []string) <-chan RepoResult {
+	return startRun(ctx, config, dids, extractAll)
+}
+
+// ExtractCrawl is ExtractAll for a -follow-depth crawl from seeds: each
+// finished repo adds the accounts it refers to, until config.FollowDepth
+// or config.MaxDIDs is reached.
+func ExtractCrawl(ctx context.Context, config Config, seeds []string) <-chan RepoResult {
+	return startRun(ctx, config, seeds, extractCrawl)
+}
+
+// runMu keeps library runs, which share the package state, one at a time.
+var runMu sync.Mutex
+
+// startRun runs extract over dids for ExtractAll and ExtractCrawl, setting
+// the run up with newRun first.
+func startRun(ctx context.Context, config Config, dids []string, extract func(context.Context, Config, []string) <-chan RepoResult) <-chan RepoResult {
+	out := make(chan RepoResult)
+
+	go func() {
+		defer close(out)
+		runMu.Lock()
+		defer runMu.Unlock()
+
+		config, done, err := newRun(ctx, config)
+		if err == nil {
+			err = ensureDirectories(config)
+			if err != nil {
+				done()
+			}
+		}
+		if err != nil {
+			for i, did := range dids {
+				res := RepoResult{DID: did, Status: StatusError, Error: err.Error(), index: i, given: did}
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+			return
+		}
+		defer done()
+
+		// after ctx is cancelled, keep draining so that nothing is still
+		// running when done tears the run down
+		for res := range extract(ctx, config, dids) {
+			select {
+			case out <- res:
+			case <-ctx.Done():
+			}
+		}
+		if err := dedup.writeReport(config.DedupReport); err != nil {
+			logf("Warning: failed to write dedup report: %v\n", err)
+		}
+	}()
+
+	return out
+}
+
+// newRun sets up the process-wide state that the workers of a run share
+// (the event stream, caches, limiters, index and sessions) as config asks,
+// returning config as the run uses it and a function that tears the state
+// down again. Main sets up the -tui dashboard and -log-file before.
+func newRun(ctx context.Context, config Config) (Config, func(), error) {
+	oldLogw := logw
+	done := func() {
+		archiveIndex.close()
+		events, dash, dedup, seenBlobs, hostLimits, cars = nil, nil, nil, nil, nil, nil
+		bandwidth, breaker, archiveIndex, session, labels = nil, nil, nil, nil, nil
+		logw = oldLogw
+	}
+
+	if config.RefreshOlderThan > 0 && config.Index == "" {
+		return config, nil, errors.New("-refresh-older-than requires -index")
+	}
+	if config.AuthIdentifier != "" && config.AuthPassword == "" {
+		return config, nil, errors.New("-auth-identifier requires ATP_AUTH_PASSWORD to be set")
+	}
+	if config.IncludeLabels != "" {
+		if _, err := syntax.ParseDID(config.IncludeLabels); err != nil {
+			return config, nil, fmt.Errorf("-include-labels: %w", err)
+		}
+	}
+
+	if config.Events {
+		events = newEventWriter(os.Stdout, config.EventRecords)
+		// the events have stdout to themselves
+		if logw == os.Stdout {
+			logw = os.Stderr
+		}
+	}
+	if config.ProgressFile != "" {
+		if dash == nil {
+			dash = newDashboard(nil, config.Concurrency)
+		}
+		dash.progressPath = config.ProgressFile
+	}
+
+	if config.DedupReport != "" {
+		dedup = newDedupTracker(config.SeenFilter, config.SeenFilterFP)
+	}
+	if config.BlobStore != "" && config.SeenFilter > 0 {
+		seenBlobs = newBloomFilter(config.SeenFilter, config.SeenFilterFP)
+	}
+
+	if config.PerHost > 0 {
+		hostLimits = newHostLimiter(config.PerHost)
+	}
+
+	if config.CarCache != "" {
+		cars = &carCache{dir: config.CarCache, verify: config.VerifyResume}
+	}
+
+	if config.RecordPlugin != "" {
+		h, err := loadRecordPlugin(config.RecordPlugin)
+		if err != nil {
+			done()
+			return config, nil, fmt.Errorf("failed to load -record-plugin: %w", err)
+		}
+		config.RecordHandler = h
+	}
+
+	if config.MaxBandwidth > 0 {
+		bandwidth = newBandwidthLimiter(config.MaxBandwidth)
+	}
+
+	if config.BreakerThreshold > 0 {
+		breaker = newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown)
+	}
+
+	if config.Index != "" {
+		ri, err := openRepoIndex(config.Index)
+		if err != nil {
+			done()
+			return config, nil, err
+		}
+		archiveIndex = ri
+	}
+
+	if config.AuthIdentifier != "" {
+		s, err := newAuthSession(ctx, config.AuthIdentifier, config.AuthPassword)
+		if err != nil {
+			done()
+			return config, nil, err
+		}
+		session = s
+	}
+	if config.IncludeLabels != "" {
+		ls, err := newLabelSource(ctx, config.IncludeLabels)
+		if err != nil {
+			logf("Warning: can't reach labeler %s, no labels will be saved: %v\n", config.IncludeLabels, err)
+		}
+		labels = ls
+	}
+	if config.IncludeAccountData && session == nil {
+		logf("Warning: -include-account-data needs -auth-identifier; no account data will be saved\n")
+	}
+
+	return config, done, nil
+}
+
+// extractAll is ExtractAll for a run that is already set up.
+func extractAll(ctx context.Context, config Config, dids []string) <-chan RepoResult {
+	jobs := make(chan extractJob)
+
+	go func() {
+		defer close(jobs)
+		for _, i := range scheduleOrder(ctx, config, dids) {
+			select {
+			case jobs <- extractJob{index: i, did: dids[i]}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return runWorkers(ctx, config, jobs, nil)
+}
+
+// extractQueue is extractAll for DIDs claimed one at a time from a -queue,
+// recording each outcome there. The channel is closed once nothing is left
+// to claim.
+func extractQueue(ctx context.Context, config Config, q *workQueue) <-chan RepoResult {
+	jobs := make(chan extractJob)
+
+	go func() {
+		defer close(jobs)
+		for i := 0; ; i++ {
+			did, ok, err := q.claim(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logf("Error: failed to claim from queue: %v\n", err)
+				}
+				return
+			}
+			if !ok {
+				return
+			}
+			select {
+			case jobs <- extractJob{index: i, did: did}:
+			case <-ctx.Done():
+				if err := q.release(did); err != nil {
+					logf("Warning: failed to release %s to the queue: %v\n", did, err)
+				}
+				return
+			}
+		}
+	}()
+
+	return runWorkers(ctx, config, jobs, func(job extractJob, res RepoResult) {
+		if err := q.finish(job.did, res, ctx.Err() != nil); err != nil {
+			logf("Warning: failed to record %s in the queue: %v\n", job.did, err)
+		}
+	})
+}
+
+// extractCrawl is ExtractCrawl for a run that is already set up. The
+// channel is closed once no repo is left to extract.
+func extractCrawl(ctx context.Context, config Config, seeds []string) <-chan RepoResult {
+	cf := newCrawlFrontier(seeds, config.FollowDepth, config.MaxDIDs)
+	stop := context.AfterFunc(ctx, cf.wake)
+	jobs := make(chan extractJob)
+
+	go func() {
+		defer close(jobs)
+		defer stop()
+		for {
+			job, ok := cf.take(ctx)
+			if !ok {
+				return
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return runWorkers(ctx, config, jobs, cf.done)
+}
+
+// extractJob is one entry of the DIDs list and its position in it. In a
+// -follow-depth crawl, depth is how many references away from a seed the
+// account was found.
+type extractJob struct {
+	index int
+	did   string
+	depth int
+}
+
+// runWorkers runs jobs on config.Concurrency workers, calling finished
+// (when not nil) with each result before it is sent. A repo whose host is
+// at its -per-host limit gives up its worker to the next job and waits
+// aside until the host has a free slot, then takes a worker again.
+func runWorkers(ctx context.Context, config Config, jobs <-chan extractJob, finished func(extractJob, RepoResult)) <-chan RepoResult {
+	results := make(chan RepoResult)
+
+	// the free workers, by index for the dashboard
+	workers := make(chan int, max(config.Concurrency, 1))
+	for i := 0; i < cap(workers); i++ {
+		workers <- i
+	}
+
+	var wg sync.WaitGroup
+	var run func(i int, job extractJob)
+	run = func(i int, job extractJob) {
+		defer wg.Done()
+		dash.begin(i, job.did)
+		res := extractOne(ctx, job.did, config)
+		if res.hostFree != nil {
+			dash.setAside(i, job.did, res.DID)
+			workers <- i
+			wg.Add(1)
+			go func() {
+				select {
+				case <-res.hostFree:
+				case <-ctx.Done():
+				}
+				run(<-workers, job)
+			}()
+			return
+		}
+		res.index = job.index
+		res.given = job.did
+		if finished != nil {
+			finished(job, res)
+		}
+		dash.end(i, job.did, res)
+		select {
+		case results <- res:
+		case <-ctx.Done():
+		}
+		workers <- i
+	}
+
+	go func() {
+		for job := range jobs {
+			i := <-workers
+			wg.Add(1)
+			go run(i, job)
+		}
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// extractOne processes a single DID and reports the outcome through the
+// log, the event stream and the webhook.
+func extractOne(ctx context.Context, did string, config Config) RepoResult {
+	res, err := processRepo(ctx, did, config)
+	if errors.Is(err, errHostBusy) {
+		// not an outcome: runWorkers runs it again later
+		return res
+	}
+	if err != nil {
+		logf("Error processing %s: %v\n", did, err)
+		events.emit(Event{Type: EventRepoError, DID: did, Error: err.Error()})
+		res.Status = StatusError
+		res.Error = err.Error()
+		res.transient = transientError(err, config)
+		var unsupported *UnsupportedDIDMethodError
+		if errors.As(err, &unsupported) {
+			res.Status = StatusUnsupported
+		}
+		if errors.Is(err, ErrRepoLocked) {
+			res.Status = StatusLocked
+		}
+		var recErr *RecordError
+		if errors.As(err, &recErr) {
+			res.Status = StatusRecordError
+		}
+		var regression *RevRegressionError
+		if errors.As(err, &regression) {
+			res.Status = StatusRevRegression
+		}
+	} else {
+		res.Status = StatusOK
+	}
+
+	if config.PostCommand != "" && res.Status == StatusOK {
+		out, err := runPostCommand(ctx, config.PostCommand, res)
+		if len(out) > 0 {
+			logf("%s", out)
+		}
+		if err != nil {
+			logf("Warning: post command for %s failed: %v\n", did, err)
+			res.PostCmdError = err.Error()
+		}
+	}
+
+	if config.WebhookURL != "" {
+		if err := notifyWebhook(ctx, config.WebhookURL, res); err != nil {
+			logf("Warning: webhook for %s failed: %v\n", did, err)
+		}
+	}
+	return res
+}
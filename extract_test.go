@@ -0,0 +1,51 @@
+package extractor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractAll(t *testing.T) {
+	const did = "did:plc:testtesttesttesttesttest"
+	dir := t.TempDir()
+	config := Config{
+		CarsDir:     filepath.Join(dir, "cars"),
+		RecordsDir:  filepath.Join(dir, "records"),
+		Concurrency: 2,
+		PerHost:     1,
+		DedupReport: filepath.Join(dir, "dedup.json"),
+		LocalCars:   map[string]string{did: filepath.Join("testdata", "repo.car")},
+	}
+
+	var got []RepoResult
+	for res := range ExtractAll(context.Background(), config, []string{did}) {
+		got = append(got, res)
+	}
+	if len(got) != 1 || got[0].Status != StatusOK || got[0].Records != 6 {
+		t.Fatalf("ExtractAll = %+v, want one ok result with 6 records", got)
+	}
+	// the run's state was set up from config, and torn down again
+	if _, err := os.Stat(config.DedupReport); err != nil {
+		t.Errorf("no -dedup-report written: %v", err)
+	}
+	if dedup != nil || hostLimits != nil {
+		t.Error("the run's state is still set up after its results were drained")
+	}
+}
+
+func TestExtractAllSetupError(t *testing.T) {
+	config := Config{RefreshOlderThan: 1, CarsDir: t.TempDir(), RecordsDir: t.TempDir()}
+	dids := []string{"did:plc:a", "did:plc:b"}
+	n := 0
+	for res := range ExtractAll(context.Background(), config, dids) {
+		if res.Status != StatusError || res.Error == "" {
+			t.Errorf("result for %s = %+v, want the setup error", res.DID, res)
+		}
+		n++
+	}
+	if n != len(dids) {
+		t.Errorf("got %d results, want %d", n, len(dids))
+	}
+}
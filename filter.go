@@ -0,0 +1,308 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// recordFilter is a compiled -filter expression. The language is small:
+//
+//	expr  := and ("||" and)*
+//	and   := unary ("&&" unary)*
+//	unary := "!" unary | "(" expr ")" | path [("==" | "!=") value]
+//
+// A path is a dotted field path into the record's JSON form, such as
+// "$type" or "reply.parent.uri"; "[]" after a name matches any element of
+// an array ("facets[].features[].$type"). A value is a quoted string, a
+// number, true, false, null or a bare word taken as a string. A path on its
+// own is true when it holds anything other than null or false. Comparisons
+// against "[]" paths are true when any element matches, and a missing field
+// compares as null.
+type recordFilter struct {
+	root filterNode
+}
+
+type filterNode interface {
+	eval(rec any) bool
+}
+
+type filterOr struct{ left, right filterNode }
+type filterAnd struct{ left, right filterNode }
+type filterNot struct{ inner filterNode }
+type filterTruthy struct{ path []string }
+type filterCompare struct {
+	path  []string
+	neg   bool
+	value any
+}
+
+func (n filterOr) eval(rec any) bool  { return n.left.eval(rec) || n.right.eval(rec) }
+func (n filterAnd) eval(rec any) bool { return n.left.eval(rec) && n.right.eval(rec) }
+func (n filterNot) eval(rec any) bool { return !n.inner.eval(rec) }
+
+func (n filterTruthy) eval(rec any) bool {
+	for _, v := range lookupPath(rec, n.path) {
+		if v != nil && v != false {
+			return true
+		}
+	}
+	return false
+}
+
+func (n filterCompare) eval(rec any) bool {
+	matched := false
+	for _, v := range lookupPath(rec, n.path) {
+		if filterEqual(v, n.value) {
+			matched = true
+			break
+		}
+	}
+	return matched != n.neg
+}
+
+// lookupPath returns every value at path in a generic JSON value. A missing
+// field yields a single nil, so it compares equal to null.
+func lookupPath(v any, path []string) []any {
+	if len(path) == 0 {
+		return []any{v}
+	}
+	name, each := strings.CutSuffix(path[0], "[]")
+	m, ok := v.(map[string]any)
+	if !ok {
+		return []any{nil}
+	}
+	child, ok := m[name]
+	if !ok {
+		return []any{nil}
+	}
+	if !each {
+		return lookupPath(child, path[1:])
+	}
+	arr, ok := child.([]any)
+	if !ok {
+		return []any{nil}
+	}
+	var out []any
+	for _, elem := range arr {
+		out = append(out, lookupPath(elem, path[1:])...)
+	}
+	return out
+}
+
+func filterEqual(v, want any) bool {
+	switch w := want.(type) {
+	case nil:
+		return v == nil
+	case bool:
+		return v == w
+	case float64:
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == w
+	case string:
+		s, ok := v.(string)
+		return ok && s == w
+	}
+	return false
+}
+
+// match reports whether a decoded record passes the filter. A nil filter
+// passes everything.
+func (f *recordFilter) match(rec any) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	generic, err := toGeneric(rec)
+	if err != nil {
+		return false, err
+	}
+	return f.root.eval(generic), nil
+}
+
+// parseFilter compiles a -filter expression; an empty one gives a nil
+// filter.
+func parseFilter(expr string) (*recordFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	toks, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("invalid filter: unexpected %q", p.toks[p.pos].text)
+	}
+	return &recordFilter{root: root}, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var toks []filterToken
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			toks = append(toks, filterToken{text: string(r)})
+			i++
+		case r == '!' && i+1 < len(rs) && rs[i+1] == '=',
+			r == '=' && i+1 < len(rs) && rs[i+1] == '=',
+			r == '&' && i+1 < len(rs) && rs[i+1] == '&',
+			r == '|' && i+1 < len(rs) && rs[i+1] == '|':
+			toks = append(toks, filterToken{text: string(rs[i : i+2])})
+			i += 2
+		case r == '!':
+			toks = append(toks, filterToken{text: "!"})
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("invalid filter: unterminated string")
+			}
+			s, err := strconv.Unquote(string(rs[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter: bad string %s", string(rs[i:j+1]))
+			}
+			toks = append(toks, filterToken{text: s, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune("()!=&|\"", rs[j]) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("invalid filter: unexpected %q", string(r))
+			}
+			toks = append(toks, filterToken{text: string(rs[i:j])})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type filterParser struct {
+	toks []filterToken
+	pos  int
+}
+
+func (p *filterParser) peek(text string) bool {
+	return p.pos < len(p.toks) && !p.toks[p.pos].quoted && p.toks[p.pos].text == text
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	switch {
+	case p.peek("!"):
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{inner}, nil
+	case p.peek("("):
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	tok := p.toks[p.pos]
+	if tok.quoted || strings.ContainsAny(tok.text, "()") || tok.text == "==" || tok.text == "!=" {
+		return nil, fmt.Errorf("expected a field path, got %q", tok.text)
+	}
+	p.pos++
+	path := strings.Split(strings.TrimPrefix(tok.text, "."), ".")
+
+	if !p.peek("==") && !p.peek("!=") {
+		return filterTruthy{path}, nil
+	}
+	neg := p.toks[p.pos].text == "!="
+	p.pos++
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("missing value after comparison")
+	}
+	vt := p.toks[p.pos]
+	p.pos++
+	return filterCompare{path: path, neg: neg, value: filterValue(vt)}, nil
+}
+
+func filterValue(tok filterToken) any {
+	if tok.quoted {
+		return tok.text
+	}
+	switch tok.text {
+	case "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if f, err := strconv.ParseFloat(tok.text, 64); err == nil {
+		return f
+	}
+	return tok.text
+}
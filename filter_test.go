@@ -0,0 +1,100 @@
+package extractor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLexFilter(t *testing.T) {
+	toks, err := lexFilter(`a.b == "x y" && !(c != 3)||d`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []filterToken{
+		{text: "a.b"}, {text: "=="}, {text: "x y", quoted: true}, {text: "&&"},
+		{text: "!"}, {text: "("}, {text: "c"}, {text: "!="}, {text: "3"}, {text: ")"},
+		{text: "||"}, {text: "d"},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("lexFilter = %+v, want %+v", toks, want)
+	}
+
+	for _, expr := range []string{`a == "open`, `a = b`, `a & b`} {
+		if _, err := lexFilter(expr); err == nil {
+			t.Errorf("lexFilter(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	reply := map[string]any{
+		"$type": "app.bsky.feed.post",
+		"text":  "hi",
+		"reply": map[string]any{"parent": map[string]any{"uri": "at://did:plc:x/app.bsky.feed.post/1"}},
+		"langs": []any{"en"},
+		"facets": []any{
+			map[string]any{"features": []any{map[string]any{"$type": "app.bsky.richtext.facet#link"}}},
+		},
+		"count": 3,
+		"draft": false,
+	}
+	post := map[string]any{"$type": "app.bsky.feed.post", "text": "hello"}
+	like := map[string]any{"$type": "app.bsky.feed.like"}
+
+	tests := []struct {
+		expr string
+		rec  any
+		want bool
+	}{
+		{`$type == app.bsky.feed.post && reply != null`, reply, true},
+		{`$type == app.bsky.feed.post && reply != null`, post, false},
+		{`$type == "app.bsky.feed.post"`, like, false},
+		{`reply.parent.uri`, reply, true},
+		{`reply.parent.uri`, post, false},
+		{`facets[].features[].$type == "app.bsky.richtext.facet#link"`, reply, true},
+		{`facets[].features[].$type == "app.bsky.richtext.facet#link"`, post, false},
+		{`langs[] == en`, reply, true},
+		{`count == 3`, reply, true},
+		{`count != 3`, reply, false},
+		{`draft`, reply, false},
+		{`draft == false`, reply, true},
+		{`missing == null`, post, true},
+		{`!reply`, post, true},
+		{`!(reply || $type == app.bsky.feed.like)`, like, false},
+		{`$type == app.bsky.feed.like || $type == app.bsky.feed.post && reply`, post, false},
+		{`$type == app.bsky.feed.like || $type == app.bsky.feed.post && reply`, like, true},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tt.expr, err)
+			continue
+		}
+		got, err := f.match(tt.rec)
+		if err != nil {
+			t.Errorf("%q: match: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q on %v = %v, want %v", tt.expr, tt.rec, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterEmpty(t *testing.T) {
+	f, err := parseFilter("  ")
+	if err != nil || f != nil {
+		t.Fatalf("parseFilter of blank = %v, %v; want nil, nil", f, err)
+	}
+	if ok, err := f.match(map[string]any{}); !ok || err != nil {
+		t.Errorf("nil filter match = %v, %v; want true, nil", ok, err)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{`a ==`, `(a`, `a)`, `a b`, `&& a`, `!`, `a == "x`} {
+		if _, err := parseFilter(expr); err == nil {
+			t.Errorf("parseFilter(%q) succeeded, want an error", expr)
+		}
+	}
+}
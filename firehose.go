@@ -0,0 +1,168 @@
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// frameHeader is the first of the two CBOR objects in a firehose frame.
+// Op is 1 for a message, whose type is in T, and -1 for an error.
+type frameHeader struct {
+	Op int64  `refmt:"op"`
+	T  string `refmt:"t"`
+}
+
+func init() {
+	cbor.RegisterCborType(frameHeader{})
+}
+
+// runFrame implements the "frame" subcommand, which extracts the records
+// carried by captured com.atproto.sync.subscribeRepos #commit frames: each
+// op becomes an NDJSON line with its action, and creates and updates carry
+// the record from the frame's CAR slice. A file may hold several frames
+// back to back; frames of other types are skipped.
+func runFrame(args []string) error {
+	config := Config{}
+	fs := flag.NewFlagSet("frame", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s frame [flags] <frame-file|->\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&config.Typed, "typed", true, "decode records of known lexicons into their indigo types, others generically (false: decode all generically)")
+	fs.BoolVar(&config.SkipCommitFile, "no-commit", false, "don't write a commit line before each frame's records")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	logw = os.Stderr
+	br := bufio.NewReader(in)
+	w := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(w)
+
+	ctx := context.Background()
+	frames, ops := 0, 0
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+		var hdr cbg.Deferred
+		if err := hdr.UnmarshalCBOR(br); err != nil {
+			return fmt.Errorf("frame %d: reading header: %w", frames+1, err)
+		}
+		var h frameHeader
+		if err := cbor.DecodeInto(hdr.Raw, &h); err != nil {
+			return fmt.Errorf("frame %d: decoding header: %w", frames+1, err)
+		}
+		frames++
+		if h.Op != 1 || h.T != "#commit" {
+			var body cbg.Deferred
+			if err := body.UnmarshalCBOR(br); err != nil {
+				return fmt.Errorf("frame %d: reading body: %w", frames, err)
+			}
+			logf("Skipping frame %d (op %d, %q)\n", frames, h.Op, h.T)
+			continue
+		}
+		var evt comatproto.SyncSubscribeRepos_Commit
+		if err := evt.UnmarshalCBOR(br); err != nil {
+			return fmt.Errorf("frame %d: decoding commit: %w", frames, err)
+		}
+		n, err := writeCommitOps(ctx, enc, &evt, config)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", frames, err)
+		}
+		ops += n
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	logf("Done: %d ops from %d frames\n", ops, frames)
+	return nil
+}
+
+// writeCommitOps writes the ops of one #commit event as NDJSON, returning
+// how many were written.
+func writeCommitOps(ctx context.Context, enc *json.Encoder, evt *comatproto.SyncSubscribeRepos_Commit, config Config) (int, error) {
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	if len(evt.Blocks) > 0 {
+		if _, err := repo.IngestRepo(ctx, bs, bytes.NewReader(evt.Blocks)); err != nil {
+			return 0, fmt.Errorf("reading blocks: %w", err)
+		}
+	} else if evt.TooBig {
+		logf("Warning: commit %s of %s is tooBig and carries no blocks; records are missing\n", evt.Rev, evt.Repo)
+	}
+
+	if !config.SkipCommitFile {
+		var sc repo.SignedCommit
+		err := util.CborStore(bs).Get(ctx, cid.Cid(evt.Commit), &sc)
+		if err == nil {
+			if err := enc.Encode(StreamLine{Type: "commit", Commit: &sc}); err != nil {
+				return 0, err
+			}
+		} else if len(evt.Blocks) > 0 {
+			logf("Warning: commit block of %s rev %s not in frame: %v\n", evt.Repo, evt.Rev, err)
+		}
+	}
+
+	n := 0
+	for _, op := range evt.Ops {
+		collection, rkey, _ := strings.Cut(op.Path, "/")
+		line := StreamLine{
+			Type:       "record",
+			Action:     op.Action,
+			URI:        "at://" + evt.Repo + "/" + op.Path,
+			Collection: collection,
+			Rkey:       rkey,
+		}
+		if op.Cid != nil {
+			c := cid.Cid(*op.Cid)
+			line.CID = c.String()
+			blk, err := bs.Get(ctx, c)
+			switch {
+			case ipld.IsNotFound(err):
+				logf("Warning: record %s not in frame\n", line.URI)
+			case err != nil:
+				return n, err
+			default:
+				rec, err := decodeRecord(blk.RawData(), config.Typed)
+				if err != nil {
+					logf("Warning: Failed to decode record %s: %v\n", line.URI, err)
+				} else {
+					line.Value = rec
+				}
+			}
+		}
+		if err := enc.Encode(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,52 @@
+package extractor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// commitRecordsToGit commits the records directory to a git repository
+// rooted there, creating the repository on first use, so runs can be
+// compared with git diff. Nothing is committed if no file changed.
+func commitRecordsToGit(dir string, report *RunReport, started time.Time) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if _, err := gitCmd(dir, "init", "-q"); err != nil {
+			return err
+		}
+	}
+	if _, err := gitCmd(dir, "add", "-A"); err != nil {
+		return err
+	}
+	status, err := gitCmd(dir, "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(status) == "" {
+		logf("git: no changes to commit in %s\n", dir)
+		return nil
+	}
+
+	msg := fmt.Sprintf("Extraction run %s: %d DIDs (%d ok, %d failed)",
+		started.UTC().Format(time.RFC3339), report.Total, report.OK, report.Failed+report.Unsupported)
+	if _, err := gitCmd(dir, "commit", "-q", "-m", msg); err != nil {
+		return err
+	}
+	logf("git: committed %s\n", dir)
+	return nil
+}
+
+func gitCmd(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out.String(), nil
+}
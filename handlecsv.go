@@ -0,0 +1,93 @@
+package extractor
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// readHandleCSV reads a CSV of did,handle rows, as given by -dids-csv. A
+// header row naming "did" and "handle" columns may put them in any order;
+// without one the first two columns are taken as did and handle. It returns
+// the DIDs in file order and their handles.
+func readHandleCSV(path string) ([]string, map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	didCol, handleCol := 0, 1
+	var dids []string
+	handles := map[string]string{}
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if line == 1 && !strings.HasPrefix(row[0], "did:") {
+			didCol, handleCol = -1, -1
+			for i, name := range row {
+				switch strings.ToLower(strings.TrimSpace(name)) {
+				case "did":
+					didCol = i
+				case "handle":
+					handleCol = i
+				}
+			}
+			if didCol < 0 || handleCol < 0 {
+				return nil, nil, fmt.Errorf("%s: header needs did and handle columns", path)
+			}
+			continue
+		}
+		if len(row) <= max(didCol, handleCol) {
+			return nil, nil, fmt.Errorf("%s:%d: want did and handle columns", path, line)
+		}
+		did, err := syntax.ParseDID(strings.TrimSpace(row[didCol]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		handle, err := syntax.ParseHandle(strings.TrimSpace(row[handleCol]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if _, ok := handles[did.String()]; !ok {
+			dids = append(dids, did.String())
+		}
+		handles[did.String()] = handle.Normalize().String()
+	}
+	return dids, handles, nil
+}
+
+// didDocDirectory resolves DID documents without checking handles, for
+// DIDs whose handle the caller already knows.
+var didDocDirectory = &identity.BaseDirectory{
+	PLCURL:     identity.DefaultPLCURL,
+	HTTPClient: http.Client{Timeout: 15 * time.Second},
+}
+
+// lookupKnownHandle resolves did's document for its PDS and key but takes
+// the handle from -dids-csv instead of resolving the declared one.
+func lookupKnownHandle(ctx context.Context, did syntax.DID, handle string) (*identity.Identity, error) {
+	doc, err := didDocDirectory.ResolveDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	ident := identity.ParseIdentity(doc)
+	ident.Handle = syntax.Handle(handle)
+	return &ident, nil
+}
@@ -0,0 +1,108 @@
+package extractor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// highWater holds, per collection NSID, the greatest TID rkey extracted
+// from it so far. The marks are stored per repo in _highwater.json. A nil
+// *highWater treats nothing as seen.
+type highWater struct {
+	marks map[string]string
+	// held collections had a record this run failed on or skipped, so
+	// their mark stays below it and the next run tries it again
+	held map[string]bool
+}
+
+func highWaterPath(recordsPath string) string {
+	return filepath.Join(recordsPath, "_highwater.json")
+}
+
+func loadHighWater(recordsPath string) (*highWater, error) {
+	hw := &highWater{marks: map[string]string{}, held: map[string]bool{}}
+	b, err := os.ReadFile(highWaterPath(recordsPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return hw, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &hw.marks); err != nil {
+		return nil, err
+	}
+	return hw, nil
+}
+
+func (hw *highWater) save(recordsPath string) error {
+	if hw == nil {
+		return nil
+	}
+	b, err := json.MarshalIndent(hw.marks, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return os.WriteFile(highWaterPath(recordsPath), b, 0666)
+}
+
+// seen reports whether the record at key k is at or below the stored mark
+// for its collection. Only TID rkeys take part, since only those sort by
+// creation time; records with other rkeys (like a profile's "self") are
+// never treated as seen.
+func (hw *highWater) seen(k string) bool {
+	if hw == nil {
+		return false
+	}
+	collection, rkey, ok := tidKey(k)
+	if !ok {
+		return false
+	}
+	prev, ok := hw.marks[collection]
+	return ok && rkey <= prev
+}
+
+// advance raises the mark of k's collection to include k, once the record
+// has been written. Records come in key order, so the mark only moves up.
+func (hw *highWater) advance(k string) {
+	if hw == nil {
+		return
+	}
+	collection, rkey, ok := tidKey(k)
+	if !ok || hw.held[collection] {
+		return
+	}
+	if rkey > hw.marks[collection] {
+		hw.marks[collection] = rkey
+	}
+}
+
+// hold stops the mark of k's collection from moving for the rest of the
+// run, for a record that wasn't written: one that couldn't be read, or
+// that -max-record-age left out.
+func (hw *highWater) hold(k string) {
+	if hw == nil {
+		return
+	}
+	if collection, _, ok := tidKey(k); ok {
+		hw.held[collection] = true
+	}
+}
+
+// tidKey splits k into its collection and rkey if the rkey is a TID.
+func tidKey(k string) (string, string, bool) {
+	collection, rkey, ok := strings.Cut(k, "/")
+	if !ok {
+		return "", "", false
+	}
+	if _, err := syntax.ParseTID(rkey); err != nil {
+		return "", "", false
+	}
+	return collection, rkey, true
+}
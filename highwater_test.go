@@ -0,0 +1,89 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+func tidAt(t time.Time) string {
+	return syntax.NewTIDFromTime(t, 0).String()
+}
+
+func TestHighWaterSeen(t *testing.T) {
+	at := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	older := tidAt(at)
+	mark := tidAt(at.Add(time.Hour))
+	newer := tidAt(at.Add(2 * time.Hour))
+
+	hw := &highWater{marks: map[string]string{"app.bsky.feed.post": mark}, held: map[string]bool{}}
+	tests := []struct {
+		k    string
+		want bool
+	}{
+		{"app.bsky.feed.post/" + older, true},
+		{"app.bsky.feed.post/" + mark, true},
+		{"app.bsky.feed.post/" + newer, false},
+		{"app.bsky.feed.like/" + older, false},
+		{"app.bsky.actor.profile/self", false},
+		{"app.bsky.feed.post", false},
+	}
+	for _, tt := range tests {
+		if got := hw.seen(tt.k); got != tt.want {
+			t.Errorf("seen(%q) = %v, want %v", tt.k, got, tt.want)
+		}
+	}
+	if hw.marks["app.bsky.feed.post"] != mark || len(hw.marks) != 1 {
+		t.Errorf("seen changed the marks: %v", hw.marks)
+	}
+
+	var none *highWater
+	if none.seen("app.bsky.feed.post/" + older) {
+		t.Error("a nil *highWater saw a record")
+	}
+	none.advance("app.bsky.feed.post/" + older)
+	none.hold("app.bsky.feed.post/" + older)
+}
+
+func TestHighWaterAdvanceHold(t *testing.T) {
+	at := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	first, second, third := tidAt(at), tidAt(at.Add(time.Hour)), tidAt(at.Add(2*time.Hour))
+	hw := &highWater{marks: map[string]string{}, held: map[string]bool{}}
+
+	hw.advance("app.bsky.feed.post/" + first)
+	hw.advance("app.bsky.feed.like/" + second)
+	hw.advance("app.bsky.actor.profile/self")
+	// a record that failed stops its collection's mark, not the others'
+	hw.hold("app.bsky.feed.post/" + second)
+	hw.advance("app.bsky.feed.post/" + third)
+	hw.advance("app.bsky.feed.like/" + third)
+
+	want := map[string]string{"app.bsky.feed.post": first, "app.bsky.feed.like": third}
+	if len(hw.marks) != len(want) || hw.marks["app.bsky.feed.post"] != first || hw.marks["app.bsky.feed.like"] != third {
+		t.Errorf("marks = %v, want %v", hw.marks, want)
+	}
+	if hw.seen("app.bsky.feed.post/" + second) {
+		t.Error("the held record would be skipped next run")
+	}
+}
+
+func TestHighWaterSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	hw, err := loadHighWater(dir)
+	if err != nil || len(hw.marks) != 0 {
+		t.Fatalf("loadHighWater of a new repo = %v, %v; want empty", hw, err)
+	}
+	hw.marks["app.bsky.feed.post"] = "3kxyzabcdef22"
+	hw.held["app.bsky.feed.post"] = true
+	if err := hw.save(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadHighWater(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.marks["app.bsky.feed.post"] != "3kxyzabcdef22" || len(got.marks) != 1 || len(got.held) != 0 {
+		t.Errorf("loadHighWater = %+v, want the saved marks and nothing held", got)
+	}
+}
@@ -0,0 +1,113 @@
+package extractor
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// runHistory implements the experimental "history" subcommand. Given CARs
+// of the same repo from oldest to newest, it prints as NDJSON every record
+// that exists in one snapshot but is gone from the next, tagged with
+// "deleted": true.
+//
+// All CARs are loaded into one block store, so the later ones may be diffs
+// (as returned by getRepo with "since") provided the full CAR they build on
+// comes first. Limitations: only deletions between the given snapshots can
+// be seen, records created and deleted between two snapshots are invisible,
+// older versions of records that were updated rather than deleted are not
+// reported, and nothing is read from the firehose.
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s history <oldest.car> ... <newest.car>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	logw = os.Stderr
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	enc := json.NewEncoder(os.Stdout)
+
+	var prev *repo.Repo
+	var prevKeys map[string]cid.Cid
+	deleted := 0
+	for _, carPath := range fs.Args() {
+		r, err := ingestCar(ctx, bs, carPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", carPath, err)
+		}
+		sc := r.SignedCommit()
+		logf("%s: rev %s\n", carPath, sc.Rev)
+
+		keys := make(map[string]cid.Cid)
+		if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+			keys[k] = v
+			return nil
+		}); err != nil {
+			return fmt.Errorf("%s: %w", carPath, err)
+		}
+
+		for k, v := range prevKeys {
+			if _, ok := keys[k]; ok {
+				continue
+			}
+			_, rec, err := prev.GetRecord(ctx, k)
+			if err != nil {
+				logf("Warning: Failed to get deleted record %s: %v\n", k, err)
+				continue
+			}
+			collection, rkey, _ := strings.Cut(k, "/")
+			if err := enc.Encode(StreamLine{
+				Type:       "record",
+				URI:        "at://" + sc.Did + "/" + k,
+				Collection: collection,
+				Rkey:       rkey,
+				CID:        v.String(),
+				Value:      rec,
+				Deleted:    true,
+				DeletedIn:  sc.Rev,
+			}); err != nil {
+				return err
+			}
+			deleted++
+		}
+
+		prev, prevKeys = r, keys
+	}
+	logf("Done: %d deleted records found\n", deleted)
+	return nil
+}
+
+// ingestCar adds the blocks of a CAR file to bs and opens the repo at its
+// root.
+func ingestCar(ctx context.Context, bs blockstore.Blockstore, carPath string) (*repo.Repo, error) {
+	fi, err := openCar(carPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	payload, err := carPayload(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := repo.IngestRepo(ctx, bs, payload)
+	if err != nil {
+		return nil, err
+	}
+	return repo.OpenRepo(ctx, bs, root)
+}
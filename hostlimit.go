@@ -0,0 +1,64 @@
+package extractor
+
+import (
+	"errors"
+	"sync"
+)
+
+// errHostBusy is returned by processRepo when every -per-host slot of the
+// repo's host is taken. RepoResult.hostFree then says when to try again.
+var errHostBusy = errors.New("all slots of the host are taken")
+
+// hostLimiter caps how many repos are in flight against any one PDS host.
+// A nil *hostLimiter imposes no limit.
+type hostLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	inUse   map[string]int
+	waiting map[string][]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, inUse: make(map[string]int), waiting: make(map[string][]chan struct{})}
+}
+
+// tryAcquire takes a slot for host if one is free, returning the function
+// that gives it back. Otherwise it returns a nil function and a channel
+// that is closed when a slot of host is next given back. Nothing is held
+// for the caller meanwhile, so it has to try again.
+func (hl *hostLimiter) tryAcquire(host string) (func(), <-chan struct{}) {
+	if hl == nil {
+		return func() {}, nil
+	}
+
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	if hl.inUse[host] >= hl.limit {
+		free := make(chan struct{})
+		hl.waiting[host] = append(hl.waiting[host], free)
+		return nil, free
+	}
+	hl.inUse[host]++
+	return func() { hl.release(host) }, nil
+}
+
+// release gives back a slot of host and wakes the longest waiter for one.
+func (hl *hostLimiter) release(host string) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	hl.inUse[host]--
+	if hl.inUse[host] == 0 {
+		delete(hl.inUse, host)
+	}
+	if w := hl.waiting[host]; len(w) > 0 {
+		close(w[0])
+		if len(w) == 1 {
+			delete(hl.waiting, host)
+		} else {
+			hl.waiting[host] = w[1:]
+		}
+	}
+}
+
+// hostLimits enforces -per-host when it is set.
+var hostLimits *hostLimiter
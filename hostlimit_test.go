@@ -0,0 +1,66 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	hl := newHostLimiter(2)
+
+	releaseA1, _ := hl.tryAcquire("https://a.example")
+	releaseA2, _ := hl.tryAcquire("https://a.example")
+	if releaseA1 == nil || releaseA2 == nil {
+		t.Fatal("tryAcquire within the limit failed")
+	}
+
+	// another host has its own slots
+	releaseB, _ := hl.tryAcquire("https://b.example")
+	if releaseB == nil {
+		t.Fatal("tryAcquire of another host failed")
+	}
+	releaseB()
+
+	// a full host hands out a channel to wait on instead
+	release, first := hl.tryAcquire("https://a.example")
+	if release != nil || first == nil {
+		t.Fatal("tryAcquire of a full host took a slot")
+	}
+	_, second := hl.tryAcquire("https://a.example")
+
+	// each slot given back wakes one waiter, oldest first
+	releaseA1()
+	if !closed(first) || closed(second) {
+		t.Fatalf("after one release: first woken %v, second woken %v; want true, false", closed(first), closed(second))
+	}
+	release, _ = hl.tryAcquire("https://a.example")
+	if release == nil {
+		t.Fatal("tryAcquire after a release failed")
+	}
+	release()
+	if !closed(second) {
+		t.Fatal("second waiter not woken by the next release")
+	}
+	releaseA2()
+	if len(hl.inUse) != 0 || len(hl.waiting) != 0 {
+		t.Errorf("limiter not empty after every slot was given back: %v, %v", hl.inUse, hl.waiting)
+	}
+}
+
+func closed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(10 * time.Millisecond):
+		return false
+	}
+}
+
+func TestHostLimiterNil(t *testing.T) {
+	var hl *hostLimiter
+	for i := 0; i < 3; i++ {
+		if release, free := hl.tryAcquire("https://a.example"); release == nil || free != nil {
+			t.Fatal("a nil *hostLimiter refused a slot")
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpSink batches records as NDJSON and POSTs them to a -sink URL. Each
+// line is a StreamLine, as in "unpack -o -"; after a repo's last batch a
+// repo-done line with its record count marks it complete. A nil *httpSink
+// discards everything.
+type httpSink struct {
+	ctx   context.Context
+	url   string
+	did   string
+	buf   bytes.Buffer
+	lines int
+	sent  int
+}
+
+// httpSinkBatch and httpSinkBatchBytes bound each POST body, whichever is
+// reached first.
+const (
+	httpSinkBatch      = 500
+	httpSinkBatchBytes = 4 << 20
+	httpSinkRetries    = 4
+)
+
+var sinkClient = &http.Client{Timeout: 60 * time.Second}
+
+// sinkDone is the completion marker sent after a repo's records.
+type sinkDone struct {
+	Type    string `json:"type"`
+	DID     string `json:"did"`
+	Rev     string `json:"rev,omitempty"`
+	Records int    `json:"records"`
+}
+
+func newHTTPSink(ctx context.Context, url, did string) *httpSink {
+	if url == "" {
+		return nil
+	}
+	return &httpSink{ctx: ctx, url: url, did: did}
+}
+
+func (hs *httpSink) write(rec outRecord) error {
+	if hs == nil {
+		return nil
+	}
+	b, err := json.Marshal(StreamLine{
+		Type:       "record",
+		URI:        rec.URI,
+		Collection: rec.Collection,
+		Rkey:       rec.Rkey,
+		CID:        rec.CID,
+		Value:      rec.Value,
+	})
+	if err != nil {
+		return err
+	}
+	hs.buf.Write(b)
+	hs.buf.WriteByte('\n')
+	hs.lines++
+	if hs.lines >= httpSinkBatch || hs.buf.Len() >= httpSinkBatchBytes {
+		return hs.flush()
+	}
+	return nil
+}
+
+func (hs *httpSink) flush() error {
+	if hs.lines == 0 {
+		return nil
+	}
+	if err := hs.post(hs.buf.Bytes()); err != nil {
+		return err
+	}
+	hs.sent += hs.lines
+	hs.buf.Reset()
+	hs.lines = 0
+	return nil
+}
+
+// finish sends any buffered records followed by the repo-done marker.
+func (hs *httpSink) finish(rev string) error {
+	if hs == nil {
+		return nil
+	}
+	if err := hs.flush(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(sinkDone{Type: "repo-done", DID: hs.did, Rev: rev, Records: hs.sent})
+	if err != nil {
+		return err
+	}
+	return hs.post(append(b, '\n'))
+}
+
+// post sends one body, retrying with backoff on network errors, 429s and
+// 5xx responses.
+func (hs *httpSink) post(body []byte) error {
+	var err error
+	backoff := time.Second
+	for attempt := 0; attempt < httpSinkRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-hs.ctx.Done():
+				return hs.ctx.Err()
+			}
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = hs.postOnce(body)
+		if err == nil || !retry {
+			return err
+		}
+		logf("Warning: sink POST for %s failed (attempt %d): %v\n", hs.did, attempt+1, err)
+	}
+	return fmt.Errorf("sink: giving up after %d attempts: %w", httpSinkRetries, err)
+}
+
+func (hs *httpSink) postOnce(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(hs.ctx, http.MethodPost, hs.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Repo-DID", hs.did)
+
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("sink returned %s", resp.Status)
+}
+
+// validSinkURL reports whether url looks like something -sink can POST to.
+func validSinkURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
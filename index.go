@@ -0,0 +1,258 @@
+package extractor
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var indexBucket = []byte("repos")
+
+// IndexEntry is what the archive index remembers about one account.
+// LastArchived, Rev and Empty only change on a successful extraction;
+// LastAttempt, Status and Error describe the most recent try.
+type IndexEntry struct {
+	DID            string    `json:"did"`
+	Handle         string    `json:"handle,omitempty"`
+	HandleVerified bool      `json:"handle_verified"`
+	PDS            string    `json:"pds,omitempty"`
+	Rev            string    `json:"rev,omitempty"`
+	LastArchived   time.Time `json:"last_archived,omitempty"`
+	LastAttempt    time.Time `json:"last_attempt"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	Empty          bool      `json:"empty,omitempty"`
+}
+
+// repoIndex is the bbolt database behind -index. A nil *repoIndex ignores
+// updates.
+type repoIndex struct {
+	db *bolt.DB
+}
+
+func openRepoIndex(path string) (*repoIndex, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(indexBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &repoIndex{db: db}, nil
+}
+
+func (ri *repoIndex) close() error {
+	if ri == nil {
+		return nil
+	}
+	return ri.db.Close()
+}
+
+// update merges the outcome of one repo into its entry. Repos skipped
+// because another extractor held their lock are left to that extractor.
+func (ri *repoIndex) update(res RepoResult, at time.Time) error {
+	if ri == nil || res.Status == StatusLocked {
+		return nil
+	}
+	return ri.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(indexBucket)
+		var ent IndexEntry
+		if raw := b.Get([]byte(res.DID)); raw != nil {
+			if err := json.Unmarshal(raw, &ent); err != nil {
+				return err
+			}
+		}
+		ent.DID = res.DID
+		if res.Handle != "" {
+			ent.Handle = res.Handle
+			if !res.HandleVerified && res.DeclaredHandle != "" {
+				ent.Handle = res.DeclaredHandle
+			}
+			ent.HandleVerified = res.HandleVerified
+		}
+		if res.PDS != "" {
+			ent.PDS = res.PDS
+		}
+		ent.LastAttempt = at.UTC()
+		ent.Status = res.Status
+		ent.Error = res.Error
+		if res.Status == StatusOK {
+			ent.LastArchived = at.UTC()
+			ent.Empty = res.Empty
+			if res.Rev != "" {
+				ent.Rev = res.Rev
+			}
+		}
+		raw, err := json.Marshal(ent)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(res.DID), raw)
+	})
+}
+
+// rev returns the last rev archived successfully for did, if any.
+func (ri *repoIndex) rev(did string) string {
+	if ri == nil {
+		return ""
+	}
+	var ent IndexEntry
+	ri.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(indexBucket).Get([]byte(did)); raw != nil {
+			return json.Unmarshal(raw, &ent)
+		}
+		return nil
+	})
+	return ent.Rev
+}
+
+// entries returns every indexed account, ordered by DID.
+func (ri *repoIndex) entries() ([]IndexEntry, error) {
+	var out []IndexEntry
+	err := ri.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(indexBucket).ForEach(func(k, v []byte) error {
+			var ent IndexEntry
+			if err := json.Unmarshal(v, &ent); err != nil {
+				return fmt.Errorf("bad index entry %s: %w", k, err)
+			}
+			out = append(out, ent)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// staleDIDs returns the entries of dids that weren't archived successfully
+// within maxAge, matching each against the index by DID or, for handles in
+// the DIDs file, by last-known handle. Entries the index doesn't know are
+// kept.
+func (ri *repoIndex) staleDIDs(dids []string, maxAge time.Duration, now time.Time) ([]string, error) {
+	ents, err := ri.entries()
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]IndexEntry, 2*len(ents))
+	for _, ent := range ents {
+		byKey[ent.DID] = ent
+		if ent.Handle != "" && ent.HandleVerified {
+			byKey[ent.Handle] = ent
+		}
+	}
+
+	cutoff := now.Add(-maxAge)
+	var out []string
+	for _, did := range dids {
+		ent, ok := byKey[did]
+		if ok && ent.LastArchived.After(cutoff) {
+			continue
+		}
+		out = append(out, did)
+	}
+	return out, nil
+}
+
+// parseAge parses a duration like time.ParseDuration, also accepting a
+// whole number of days such as "7d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ageVar registers a duration flag that also accepts days (see parseAge).
+func ageVar(fs *flag.FlagSet, p *time.Duration, name, usage string) {
+	fs.Func(name, usage, func(s string) error {
+		d, err := parseAge(s)
+		if err != nil {
+			return err
+		}
+		*p = d
+		return nil
+	})
+}
+
+// archiveIndex records every processed repo when -index is given.
+var archiveIndex *repoIndex
+
+// runIndex implements the "index" subcommand, which lists the accounts in an
+// archive index, or with -stale only the DIDs due for a refresh.
+func runIndex(args []string) error {
+	var stale time.Duration
+	var asJSON bool
+
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s index [flags] <index-db>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	ageVar(fs, &stale, "stale", "only print the DIDs not archived successfully within this long (e.g. 30d or 12h), one per line")
+	fs.BoolVar(&asJSON, "json", false, "print entries as NDJSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	ri, err := openRepoIndex(path)
+	if err != nil {
+		return err
+	}
+	defer ri.close()
+	ents, err := ri.entries()
+	if err != nil {
+		return err
+	}
+
+	if stale > 0 {
+		cutoff := time.Now().Add(-stale)
+		for _, ent := range ents {
+			if ent.LastArchived.Before(cutoff) {
+				fmt.Println(ent.DID)
+			}
+		}
+		return nil
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, ent := range ents {
+			if err := enc.Encode(ent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DID\tHANDLE\tVERIFIED\tREV\tLAST ARCHIVED\tEMPTY\tSTATUS")
+	for _, ent := range ents {
+		archived := "never"
+		if !ent.LastArchived.IsZero() {
+			archived = ent.LastArchived.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%s\t%t\t%s\n", ent.DID, ent.Handle, ent.HandleVerified, ent.Rev, archived, ent.Empty, ent.Status)
+	}
+	return tw.Flush()
+}
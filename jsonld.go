@@ -0,0 +1,108 @@
+package extractor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultJSONLDContext is the @context of -format jsonld without
+// -jsonld-context. It only gives record fields and collection NSIDs an IRI;
+// real linked-data use wants a context mapping them to a shared vocabulary.
+var defaultJSONLDContext = map[string]any{
+	"@vocab": "https://atproto.com/lexicons/",
+}
+
+// loadJSONLDContext returns the @context given to -jsonld-context: a URL,
+// kept as a remote context, or a JSON file holding either the context or
+// a document with an "@context" key.
+func loadJSONLDContext(spec string) (any, error) {
+	if spec == "" {
+		return defaultJSONLDContext, nil
+	}
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return spec, nil
+	}
+	b, err := os.ReadFile(spec)
+	if err != nil {
+		return nil, err
+	}
+	var ctx any
+	if err := json.Unmarshal(b, &ctx); err != nil {
+		return nil, fmt.Errorf("invalid JSON-LD context %s: %w", spec, err)
+	}
+	if doc, ok := ctx.(map[string]any); ok {
+		if inner, ok := doc["@context"]; ok {
+			return inner, nil
+		}
+	}
+	return ctx, nil
+}
+
+// jsonldSink writes records/<did>/records.jsonld, a JSON-LD document whose
+// @graph holds every record with its at:// URI as @id and its collection
+// as @type.
+type jsonldSink struct {
+	f  *os.File
+	bw *bufio.Writer
+	n  int
+}
+
+func newJSONLDSink(path string, config Config) (*jsonldSink, error) {
+	ctx, err := loadJSONLDContext(config.JSONLDContext)
+	if err != nil {
+		return nil, err
+	}
+	head, err := json.Marshal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriterSize(f, writeBufferSize(config))
+	fmt.Fprintf(bw, "{\"@context\": %s, \"@graph\": [", head)
+	return &jsonldSink{f: f, bw: bw}, nil
+}
+
+func (js *jsonldSink) write(rec outRecord) error {
+	generic, err := toGeneric(rec.Value)
+	if err != nil {
+		return err
+	}
+	node := map[string]any{}
+	if m, ok := generic.(map[string]any); ok {
+		for k, v := range m {
+			node[k] = v
+		}
+	} else {
+		node["value"] = generic
+	}
+	node["@id"] = rec.URI
+	node["@type"] = rec.Collection
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	if js.n > 0 {
+		js.bw.WriteByte(',')
+	}
+	js.n++
+	js.bw.WriteByte('\n')
+	_, err = js.bw.Write(b)
+	return err
+}
+
+func (js *jsonldSink) close() error {
+	js.bw.WriteString("\n]}\n")
+	if err := js.bw.Flush(); err != nil {
+		js.f.Close()
+		return err
+	}
+	return js.f.Close()
+}
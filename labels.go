@@ -0,0 +1,65 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// labelSource queries one labeler for the labels it has applied to each
+// processed account. A nil *labelSource does nothing, so callers don't need
+// to check whether -include-labels is on.
+type labelSource struct {
+	did    string
+	client *xrpc.Client
+}
+
+// labels is the process-wide labeler, set up by newRun when -include-labels
+// is given and the labeler resolves.
+var labels *labelSource
+
+// newLabelSource resolves a labeler DID to its atproto_labeler service.
+func newLabelSource(ctx context.Context, labelerDID string) (*labelSource, error) {
+	did, err := syntax.ParseDID(labelerDID)
+	if err != nil {
+		return nil, err
+	}
+	ident, err := identity.DefaultDirectory().LookupDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	host := ident.GetServiceEndpoint("atproto_labeler")
+	if host == "" {
+		return nil, fmt.Errorf("%s declares no labeler service", did)
+	}
+	return &labelSource{did: did.String(), client: &xrpc.Client{Host: host}}, nil
+}
+
+// writeLabels saves the labels the labeler applied to did itself or to any
+// of its records as _labels.json under recordsPath, returning how many
+// there were.
+func (ls *labelSource) writeLabels(ctx context.Context, did, recordsPath string) (int, error) {
+	if ls == nil {
+		return 0, nil
+	}
+	found := []*comatproto.LabelDefs_Label{}
+	patterns := []string{did, "at://" + did + "/*"}
+	cursor := ""
+	for {
+		out, err := comatproto.LabelQueryLabels(ctx, ls.client, cursor, 250, []string{ls.did}, patterns)
+		if err != nil {
+			return 0, fmt.Errorf("failed to query labels: %w", err)
+		}
+		found = append(found, out.Labels...)
+		if out.Cursor == nil || *out.Cursor == "" || len(out.Labels) == 0 {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	return len(found), writeJSONFile(filepath.Join(recordsPath, "_labels.json"), found)
+}
@@ -0,0 +1,73 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// DefaultAppView is the public AppView used to expand lists and starter
+// packs.
+const DefaultAppView = "https://public.api.bsky.app"
+
+// listMemberDIDs returns the DIDs of every member of a bsky list, given an
+// at:// URI of an app.bsky.graph.list or app.bsky.graph.starterpack record.
+func listMemberDIDs(ctx context.Context, appview, uri string) ([]string, error) {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	xrpcc := &xrpc.Client{Host: appview}
+
+	switch aturi.Collection() {
+	case "app.bsky.graph.list":
+	case "app.bsky.graph.starterpack":
+		out, err := bsky.GraphGetStarterPack(ctx, xrpcc, uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch starter pack: %w", err)
+		}
+		if out.StarterPack == nil || out.StarterPack.List == nil {
+			return nil, fmt.Errorf("starter pack %s has no list", uri)
+		}
+		uri = out.StarterPack.List.Uri
+	default:
+		return nil, fmt.Errorf("%s is not a list or starter pack URI", uri)
+	}
+
+	var dids []string
+	cursor := ""
+	for {
+		out, err := bsky.GraphGetList(ctx, xrpcc, cursor, 100, uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch list: %w", err)
+		}
+		for _, item := range out.Items {
+			if item.Subject != nil {
+				dids = append(dids, item.Subject.Did)
+			}
+		}
+		if out.Cursor == nil || *out.Cursor == "" {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	return dids, nil
+}
+
+// appendNewDIDs appends the entries of add that aren't already in dids.
+func appendNewDIDs(dids, add []string) []string {
+	seen := make(map[string]bool, len(dids))
+	for _, d := range dids {
+		seen[d] = true
+	}
+	for _, d := range add {
+		if !seen[d] {
+			seen[d] = true
+			dids = append(dids, d)
+		}
+	}
+	return dids
+}
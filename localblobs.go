@@ -0,0 +1,102 @@
+package extractor
+
+import (
+	"path/filepath"
+)
+
+// blobDir returns the directory a repo's blobs are downloaded to: its
+// _blob directory, or the shared -blob-store.
+func blobDir(recordsPath string, config Config) string {
+	if config.BlobStore != "" {
+		return config.BlobStore
+	}
+	return filepath.Join(recordsPath, "_blob")
+}
+
+// localBlobRefs rewrites the blob references in records to the relative
+// paths their blobs are downloaded to, for -local-blob-refs. A nil
+// *localBlobRefs leaves records as they are.
+type localBlobRefs struct {
+	topDir string
+	depth  int
+	names  *blobNames
+}
+
+func newLocalBlobRefs(recordsPath string, config Config) (*localBlobRefs, error) {
+	if !config.LocalBlobRefs {
+		return nil, nil
+	}
+	topDir, err := filepath.Abs(blobDir(recordsPath, config))
+	if err != nil {
+		return nil, err
+	}
+	names, err := openBlobNames(topDir, config)
+	if err != nil {
+		return nil, err
+	}
+	return &localBlobRefs{topDir: topDir, depth: config.BlobShardDepth, names: names}, nil
+}
+
+// rewrite returns value with each blob's ref replaced by the path of the
+// blob file relative to fromDir, the directory of the file the record is
+// written to, and the original ref kept beside it as originalRef (or
+// originalCid for the legacy form). Records without blobs are returned
+// unchanged.
+func (lb *localBlobRefs) rewrite(value any, fromDir string) (any, error) {
+	if lb == nil {
+		return value, nil
+	}
+	generic, err := toGeneric(value)
+	if err != nil {
+		return nil, err
+	}
+	fromDir, err = filepath.Abs(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	rel := func(cidStr string) string {
+		path := lb.names.blobPath(lb.topDir, cidStr, lb.depth)
+		if r, err := filepath.Rel(fromDir, path); err == nil {
+			path = r
+		}
+		return filepath.ToSlash(path)
+	}
+
+	changed := false
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if v["$type"] == "blob" {
+				if ref, ok := v["ref"].(map[string]any); ok {
+					if link, ok := ref["$link"].(string); ok {
+						v["originalRef"] = ref
+						v["ref"] = rel(link)
+						changed = true
+						return
+					}
+				}
+			}
+			if c, ok := v["cid"].(string); ok {
+				if _, ok := v["mimeType"].(string); ok {
+					v["originalCid"] = c
+					v["cid"] = rel(c)
+					changed = true
+					return
+				}
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(generic)
+	if !changed {
+		return value, nil
+	}
+	return generic, nil
+}
@@ -0,0 +1,205 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipld/go-car/v2"
+)
+
+// carCommit reads the signed commit of the CAR at carPath, stopping at the
+// commit block instead of loading the whole repo.
+func carCommit(carPath string) (repo.SignedCommit, error) {
+	var sc repo.SignedCommit
+	fi, err := openCar(carPath)
+	if err != nil {
+		return sc, err
+	}
+	defer fi.Close()
+	payload, err := carPayload(fi)
+	if err != nil {
+		return sc, err
+	}
+	br, err := car.NewBlockReader(payload)
+	if err != nil {
+		return sc, err
+	}
+	if len(br.Roots) == 0 {
+		return sc, fmt.Errorf("CAR has no root")
+	}
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			return sc, fmt.Errorf("commit block %s not found in CAR", br.Roots[0])
+		}
+		if err != nil {
+			return sc, err
+		}
+		if blk.Cid().Equals(br.Roots[0]) {
+			if err := sc.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+				return sc, fmt.Errorf("decoding commit: %w", err)
+			}
+			return sc, nil
+		}
+	}
+}
+
+// scanCarsDir finds the CAR files in dir and reads the DID of each from its
+// commit, using workers goroutines. It returns the DIDs in file name order
+// and the CAR of each. CARs that can't be read are skipped with a warning;
+// of two CARs of the same repo the one with the later rev is used.
+func scanCarsDir(dir string, workers int) ([]string, map[string]string, error) {
+	carPaths, err := findCars(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(carPaths) == 0 {
+		return nil, nil, fmt.Errorf("no CAR files found in %s", dir)
+	}
+
+	commits := make([]repo.SignedCommit, len(carPaths))
+	errs := make([]error, len(carPaths))
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				commits[i], errs[i] = carCommit(carPaths[i])
+				if errs[i] == nil {
+					_, errs[i] = syntax.ParseDID(commits[i].Did)
+				}
+			}
+		}()
+	}
+	for i := range carPaths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var dids []string
+	cars := map[string]string{}
+	revs := map[string]string{}
+	for i, carPath := range carPaths {
+		if errs[i] != nil {
+			logf("Warning: skipping %s: %v\n", carPath, errs[i])
+			continue
+		}
+		did, rev := commits[i].Did, commits[i].Rev
+		if other, ok := cars[did]; ok {
+			if rev > revs[did] {
+				other, cars[did], revs[did] = cars[did], carPath, rev
+			}
+			logf("Warning: %s and %s both hold %s; using %s, at rev %s\n", other, carPath, did, cars[did], revs[did])
+			continue
+		}
+		dids = append(dids, did)
+		cars[did], revs[did] = carPath, rev
+	}
+	logf("Found %d repos in %d CAR files in %s\n", len(dids), len(carPaths), dir)
+	return dids, cars, nil
+}
+
+// offlineConflicts returns the options that need the network, or input
+// other than the CARs, and so can't be used with -from-cars.
+func offlineConflicts(config Config) []string {
+	var out []string
+	add := func(on bool, name string) {
+		if on {
+			out = append(out, name)
+		}
+	}
+	add(config.DIDsFile != "", "a DIDs file")
+	add(config.FromList != "", "-from-list")
+	add(config.DIDsCSV != "", "-dids-csv")
+	add(config.Queue != "", "-queue")
+	add(config.DownloadBlobs, "DOWNLOAD_BLOBS")
+	add(config.CarsOnly, "-cars-only")
+	add(config.ListBlobsOnly, "-list-blobs-only")
+	add(config.RecordLevel, "-record-level")
+	add(config.FollowDepth > 0, "-follow-depth")
+	add(config.CollectionsAuto, "-collections-auto")
+	add(config.Preflight, "-preflight")
+	add(config.NameByHandle, "-name-by-handle")
+	add(config.VerifySignatures, "-verify-signatures")
+	add(config.Provenance, "-provenance")
+	add(config.RecordSeq, "-record-seq")
+	add(config.IncludeLabels != "", "-include-labels")
+	add(config.IncludeAccountData, "-include-account-data")
+	add(config.AuthIdentifier != "", "-auth-identifier")
+	add(config.PDSDataDir != "", "-pds-data")
+	return out
+}
+
+// processLocalCar is processRepo for a repo read from a -from-cars
+// directory: nothing is resolved or fetched, so there is no identity, PDS
+// or blob to record.
+func processLocalCar(ctx context.Context, did, carPath string, st repoState, config Config) (RepoResult, error) {
+	res := RepoResult{DID: did, CarPath: carPath}
+	logf("Processing: %s from %s\n", did, carPath)
+	events.emit(Event{Type: EventRepoStart, DID: did})
+
+	// -ordered-output writes nothing into the records directory, and the
+	// ordered file has a single writer anyway
+	recordsPath := filepath.Join(config.RecordsDir, did)
+	if config.OrderedOutput == "" {
+		unlock, err := lockRepoOutput(filepath.Join(recordsPath, "_lock"))
+		if err != nil {
+			return res, err
+		}
+		defer unlock()
+	}
+
+	r, root, err := readCarRoot(ctx, carPath)
+	if err != nil {
+		return res, err
+	}
+	res.Rev = r.SignedCommit().Rev
+	st.archivedRev = archivedRev(filepath.Join(recordsPath, commitFileName(config)), did)
+	if st.archivedRev != "" && res.Rev < st.archivedRev {
+		reg := RevRegression{ArchivedRev: st.archivedRev, ServedRev: res.Rev, Forced: config.Force}
+		recordRevRegression(recordsPath, did, carPath, reg)
+		if !config.Force {
+			return res, &RevRegressionError{Archived: st.archivedRev, Served: res.Rev}
+		}
+	}
+
+	var collections map[string]int
+	if config.SummaryMD {
+		collections = map[string]int{}
+	}
+	if config.OrderedOutput != "" {
+		res.Records, res.stream, err = streamRecords(ctx, r, st, config)
+		if err != nil {
+			return res, err
+		}
+		res.Empty = res.Records == 0
+	} else {
+		res.RecordsPath = recordsPath
+		res.Records, err = unpackRepo(ctx, r, root, recordsPath, st, config, collections)
+		if errors.Is(err, ErrEmptyRepo) {
+			logf("Info: %s has no records\n", did)
+			res.Empty = true
+		} else if err != nil {
+			return res, err
+		}
+	}
+	if config.SummaryMD && res.RecordsPath != "" {
+		if err := writeSummary(res, collections, st.scope, config); err != nil {
+			return res, fmt.Errorf("failed to write summary: %w", err)
+		}
+	}
+
+	dedup.addRepo()
+	events.emit(Event{Type: EventRepoDone, DID: did, Path: recordsPath, Empty: res.Empty})
+	return res, nil
+}
@@ -1,13 +1,21 @@
-package main
+package extractor
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
-	
+	"syscall"
+	"time"
+
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	_ "github.com/bluesky-social/indigo/api/bsky"
 	_ "github.com/bluesky-social/indigo/api/chat"
@@ -17,232 +25,1903 @@ import (
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/xrpc"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/multiformats/go-multihash"
 )
 
 type Config struct {
-    DownloadBlobs bool
-    CarsDir       string
-    RecordsDir    string
-    DIDsFile      string
+	DownloadBlobs bool
+	CarsDir       string
+	RecordsDir    string
+	DIDsFile      string
+	Events        bool
+	EventRecords  bool
+
+	// SkipCommitFile suppresses the per-repo _commit.json meta file.
+	SkipCommitFile bool
+
+	// CommitFileName and RecordSuffix replace the "_commit.json" name of
+	// the commit file and the ".json" suffix of record files.
+	CommitFileName string
+	RecordSuffix   string
+
+	// MaxRecordAge skips records whose TID rkey is older than this.
+	MaxRecordAge time.Duration
+
+	// VerifySignatures checks each commit signature against the signing
+	// key in the DID document.
+	VerifySignatures bool
+
+	// DedupReport, when set, is where a report of repeated record and blob
+	// CIDs across all processed repos is written.
+	DedupReport string
+
+	// Incremental skips TID-keyed records at or below the per-collection
+	// high-water rkey stored from the previous run.
+	Incremental bool
+
+	// WebhookURL, when set, receives a POST with the RepoResult as JSON
+	// after each repo finishes.
+	WebhookURL string
+
+	// CompressCars stores downloaded CARs gzipped as <did>.car.gz.
+	CompressCars bool
+
+	// Concurrency is the number of repos processed at once.
+	Concurrency int
+
+	// Schedule is the order repos are handed to the workers in: the DIDs
+	// list order, or largest first by estimated size.
+	Schedule string
+
+	// FollowDepth crawls from the DIDs list to the accounts their follows,
+	// reposts and mentions refer to, this many steps out; MaxDIDs caps the
+	// number of accounts a crawl extracts.
+	FollowDepth int
+	MaxDIDs     int
+
+	// RecordCIDs writes a _cids.json sidecar mapping each record key to its
+	// CID.
+	RecordCIDs bool
+
+	// MaxBlobBytes and MinBlobBytes skip blobs outside the given size range;
+	// zero disables either bound.
+	MaxBlobBytes int64
+	MinBlobBytes int64
+
+	// NameByHandle names records directories after the account's handle
+	// instead of its DID.
+	NameByHandle bool
+
+	// AuthIdentifier and AuthPassword log in with an app password; requests
+	// to that account's own PDS are then authenticated.
+	AuthIdentifier string
+	AuthPassword   string
+
+	// ReportFormat is "text" or "json" for the end-of-run summary, which
+	// goes to ReportFile when that is set.
+	ReportFormat string
+	ReportFile   string
+
+	// CarsOnly stops after downloading each CAR, skipping record unpacking
+	// and blobs.
+	CarsOnly bool
+
+	// ListBlobsOnly lists each repo's blob CIDs into _blobs.txt and stops,
+	// without downloading the CAR or any blob.
+	ListBlobsOnly bool
+
+	// OrderedOutput writes every repo's commit and records as NDJSON to
+	// this one file, in DIDs-list order, instead of per-record files.
+	OrderedOutput string
+
+	// RecordLevel fetches repos whose DIDs file entry selects collections
+	// or records with listRecords and getRecord instead of the whole CAR.
+	RecordLevel bool
+
+	// IncludeLabels is a labeler DID to ask for the labels it applied to
+	// each account and its records, saved as _labels.json.
+	IncludeLabels string
+
+	// BreakerThreshold skips the remaining repos on a PDS host after that
+	// many consecutive failures against it; zero disables the breaker.
+	// BreakerCooldown lets one repo retry the host after that long; zero
+	// keeps it broken for the rest of the run.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Provenance writes provenance.json (DID doc, signed commit, CAR hash,
+	// PDS, time and tool version) for each completed repo.
+	Provenance bool
+
+	// StrictBlobs fails a repo when the PDS can't find a blob it listed,
+	// instead of skipping the blob.
+	StrictBlobs bool
+
+	// StrictRecords fails a repo on the first record that can't be read,
+	// decoded, filtered, redacted or encoded, instead of skipping it.
+	StrictRecords bool
+
+	// SummaryMD writes a human-readable SUMMARY.md for each completed repo.
+	SummaryMD bool
+
+	// Canonical writes records as canonical JSON (sorted keys, no
+	// insignificant whitespace) so unchanged records are byte-identical
+	// across runs.
+	Canonical bool
+
+	// Git commits the records directory to a git repository there after
+	// each run.
+	Git bool
+
+	// OnlyChanged leaves record files alone when their content is already
+	// what would be written, keeping mtimes stable for backup tools.
+	OnlyChanged bool
+
+	// BlobShardDepth spreads blobs over nested _blob subdirectories named
+	// by the CID's hash digest; zero keeps one flat directory.
+	BlobShardDepth int
+
+	// ShortBlobNames names blob files by 16 base32 characters of their
+	// hash digest instead of the full CID, mapped back in _blob/_index.json.
+	ShortBlobNames bool
+
+	// BlobStore, when set, is one blob directory shared by every repo in
+	// place of each repo's _blob, so a blob is downloaded once however
+	// many repos list it.
+	BlobStore string
+
+	// SeenFilter, when positive, tracks seen CIDs in bloom filters sized
+	// for this many distinct CIDs at the SeenFilterFP false-positive rate,
+	// instead of exact sets, for -dedup-report and -blob-store.
+	SeenFilter   int
+	SeenFilterFP float64
+
+	// Format selects how records are written: "json" (one file per record,
+	// the default) or one of the sink formats of newRecordSink.
+	Format string
+
+	// Typed decodes records of registered lexicons into their Go types,
+	// falling back to a generic map for unknown $types; when false every
+	// record is decoded generically.
+	Typed bool
+
+	// MaxOutputFileBytes splits -format ndjson output into numbered shards
+	// of at most this size; zero writes one file.
+	MaxOutputFileBytes int64
+
+	// JSONLDContext is the @context of -format jsonld, as a URL or a JSON
+	// file; empty uses defaultJSONLDContext.
+	JSONLDContext string
+
+	// PerHost caps how many of the Concurrency workers may target the same
+	// PDS host at once; zero means no cap.
+	PerHost int
+
+	// FromList, when set, is the at:// URI of a list or starter pack whose
+	// members are extracted, alongside any DIDs file. They are looked up on
+	// AppView.
+	FromList string
+	AppView  string
+
+	// FromCars is a directory of CAR files to extract instead of fetching
+	// repos; LocalCars maps the DID of each to its file.
+	FromCars  string
+	LocalCars map[string]string
+
+	// Preflight only checks that each distinct PDS host answers
+	// describeServer, then exits without extracting anything.
+	Preflight bool
+
+	// Stats writes _stats.json with aggregate counts over each repo's
+	// records.
+	Stats bool
+
+	// IncludeMSTMeta writes a _mst.json sidecar giving each record's depth
+	// and node path in the repo's Merkle Search Tree.
+	IncludeMSTMeta bool
+
+	// Scopes limits extraction for entries given as at:// URIs to the listed
+	// collections or "<collection>/<rkey>" records, keyed by the account as
+	// written.
+	Scopes map[string][]string
+
+	// Force overwrites an archive even when the PDS serves an older rev
+	// than the one archived.
+	Force bool
+
+	// PostCommand is run through sh after each repo completes, with the
+	// repo's details in the environment. Failures are logged and counted
+	// but don't fail the repo.
+	PostCommand string
+
+	// Index, when set, is a bbolt database updated with every processed
+	// account's handle, PDS, rev, status and last archive time.
+	Index string
+
+	// Filter is a -filter expression; only records matching it are
+	// written.
+	Filter string
+
+	// Fields picks the columns for -format csv per collection, as
+	// "collection=field,field;...".
+	Fields string
+
+	// SinkURL, when set, receives every record as batched NDJSON POSTs,
+	// followed by a repo-done marker per repo.
+	SinkURL string
+
+	// RefreshOlderThan, with Index, skips accounts the index shows were
+	// archived successfully more recently than this.
+	RefreshOlderThan time.Duration
+
+	// SeqIndex writes _order.json, every record key in MST traversal
+	// order, and adds each record's position as "seq" to NDJSON output.
+	SeqIndex bool
+
+	// ForcePDS, when set, is the host repos and blobs are fetched from in
+	// place of each DID document's PDS, such as a relay or mirror.
+	ForcePDS string
+
+	// Queue, when set, is a SQLite work queue that DIDs are claimed from,
+	// so that extractors on several machines can share one backfill.
+	// QueueLease is how long a claim lasts without being renewed.
+	Queue      string
+	QueueLease time.Duration
+
+	// RecordSeq writes _seq.json for each repo: the PDS's latest commit
+	// and SeqRelay's firehose seq just before the download.
+	RecordSeq bool
+	SeqRelay  string
+
+	// CarCache, when set, is a directory keeping the last CAR of each repo
+	// by rev, reused while the PDS reports the same rev.
+	CarCache string
+	// VerifyResume checks a cached CAR against its stored checksum before
+	// using it instead of downloading the repo.
+	VerifyResume bool
+
+	// RecordHandler, when set, is called with every record before it is
+	// written; RecordPlugin is a Go plugin providing one.
+	RecordHandler RecordHandler
+	RecordPlugin  string
+
+	// Relay, when set, is the host a repo is fetched from when its DID
+	// document has no PDS, as happens mid-migration.
+	Relay string
+
+	// DropFields and HashFields are comma-separated field paths removed
+	// from, or replaced by their SHA-256 in, every record before writing.
+	DropFields string
+	HashFields string
+
+	// IncludeAccountData saves the logged-in account's preferences and
+	// mutes, which aren't in its repo, under _account.
+	IncludeAccountData bool
+
+	// RecordWorkers decodes records concurrently in NDJSON stream mode.
+	// PreserveOrder then buffers finished lines so they are still written
+	// in MST key order.
+	RecordWorkers int
+	PreserveOrder bool
+
+	// TUI shows a live dashboard instead of log lines; the log then goes to
+	// LogFile (extract.log by default).
+	TUI     bool
+	LogFile string
+
+	// DIDFilter and DIDMethod keep only the DIDs file lines matching a
+	// regex or using one DID method.
+	DIDFilter string
+	DIDMethod string
+
+	// OnlyDIDs and SkipDIDs narrow the loaded DIDs to, or drop, the DIDs
+	// given on the command line.
+	OnlyDIDs []string
+	SkipDIDs []string
+
+	// Collections limits every repo without an at:// scope of its own to
+	// these collections, read from -collections or CollectionsFile.
+	// CollectionsAuto picks them from CollectionsSample repos instead.
+	Collections       []string
+	CollectionsFile   string
+	CollectionsAuto   bool
+	CollectionsSample int
+
+	// BlobRefs writes _blob_refs.ndjson, mapping each blob CID to the
+	// records that reference it.
+	BlobRefs bool
+
+	// RetryFailedPasses is how many more passes are made at the end of the
+	// run over the repos that failed with transient errors.
+	RetryFailedPasses int
+
+	// ProgressFile, when set, is rewritten every few seconds with the
+	// run's progress as JSON.
+	ProgressFile string
+
+	// LocalBlobRefs points the blob refs in written records at the
+	// downloaded blob files, keeping the original refs beside them.
+	LocalBlobRefs bool
+
+	// VerifyOutput reads every record file back after writing it and
+	// re-hashes the record's block against its CID.
+	VerifyOutput bool
+
+	// WriteBufferSize is the bufio buffer for streamed output files; zero
+	// means 64 KiB.
+	WriteBufferSize int
+
+	// URIList writes _uris.txt, the at:// URI of every extracted record.
+	URIList bool
+
+	// PDSDataDir reads repos (and disk-stored blobs) straight from a
+	// reference PDS's data directory instead of over the network.
+	PDSDataDir string
+
+	// DIDsCSV is a CSV of did,handle rows to extract. KnownHandles holds
+	// its handles by DID; they are used as given rather than resolved.
+	DIDsCSV      string
+	KnownHandles map[string]string
+
+	// MaxBandwidth caps the combined download rate from PDSes, in bytes
+	// per second; zero is unlimited.
+	MaxBandwidth int64
 }
 
+// ensureDirectories creates the output directories the enabled phases will
+// write to.
 func ensureDirectories(config Config) error {
-	dirs := []string{config.CarsDir, config.RecordsDir}
+	var dirs []string
+	if !config.ListBlobsOnly {
+		dirs = append(dirs, config.CarsDir)
+	}
+	if !config.CarsOnly {
+		dirs = append(dirs, config.RecordsDir)
+	}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
-	return nil
+	return nil
+}
+
+// addUnpackFlags registers the flags that control how records are written,
+// which are shared by the main command and the local CAR subcommands.
+func addUnpackFlags(fs *flag.FlagSet, config *Config) {
+	fs.BoolVar(&config.SkipCommitFile, "no-commit-file", false, "don't write the _commit.json meta file for each repo")
+	fs.StringVar(&config.CommitFileName, "commit-file-name", defaultCommitFileName, "file name of the commit meta file in each repo's directory")
+	fs.StringVar(&config.RecordSuffix, "record-suffix", defaultRecordSuffix, "suffix of record file names with -format json")
+	fs.BoolVar(&config.Incremental, "incremental", false, "only extract TID-keyed records newer than the last run's high-water rkey")
+	ageVar(fs, &config.MaxRecordAge, "max-record-age", "skip records whose TID rkey is older than this (e.g. 30d); other rkeys are always kept")
+	fs.BoolVar(&config.StrictRecords, "strict-records", false, "fail the repo if any record can't be read, decoded or written, instead of skipping it with a warning")
+	fs.BoolVar(&config.RecordCIDs, "record-cids", false, "write a _cids.json sidecar mapping record keys to CIDs")
+	fs.StringVar(&config.Format, "format", FormatJSON, "record output format: json (a file per record), msgpack (one records.msgpack per repo), csv (one <collection>.csv per collection), parquet (one <collection>.parquet per collection), jsonld (one records.jsonld per repo), bolt (one records.bolt database per repo), ndjson (one <did>.ndjson per repo) or none (with -sink)")
+	fs.StringVar(&config.JSONLDContext, "jsonld-context", "", "with -format jsonld, the @context: a URL, or a JSON file holding the context")
+	fs.Int64Var(&config.MaxOutputFileBytes, "max-output-file-bytes", 0, "with -format ndjson, roll over to numbered <did>.NNNNN.ndjson shards of at most this many bytes (0 = one file)")
+	fs.StringVar(&config.SinkURL, "sink", "", "also POST records as batched NDJSON to this URL, with a repo-done marker per repo")
+	fs.StringVar(&config.Fields, "fields", "", "columns for -format csv, as collection=field,field;... (dotted paths; common collections have defaults)")
+	fs.BoolVar(&config.OnlyChanged, "only-changed", false, "don't rewrite record files whose content is unchanged")
+	fs.BoolVar(&config.SeqIndex, "seq-index", false, "write _order.json listing record keys in MST order, and a seq field in NDJSON output")
+	fs.BoolVar(&config.URIList, "uri-list", false, "write _uris.txt listing the at:// URI of every extracted record, one per line")
+	fs.BoolVar(&config.BlobRefs, "blob-refs", false, "write _blob_refs.ndjson mapping each blob CID to the record URIs referencing it")
+	fs.BoolVar(&config.Stats, "stats", false, "write _stats.json with post, reply, repost, like and follow counts, the records' time span and counts per collection")
+	fs.BoolVar(&config.IncludeMSTMeta, "include-mst-meta", false, "write a _mst.json sidecar with each record's MST depth and node path")
+	fs.StringVar(&config.DropFields, "drop-fields", "", "remove these comma-separated field paths from records before writing (e.g. embed.external.uri)")
+	fs.StringVar(&config.HashFields, "hash-fields", "", "replace these comma-separated field paths with their SHA-256 before writing (e.g. text)")
+	fs.StringVar(&config.Filter, "filter", "", "only write records matching this expression, e.g. '$type == app.bsky.feed.post && reply != null'")
+	fs.BoolVar(&config.VerifyOutput, "verify-output", false, "read back every written record and check it, and its CID, against the CAR (doubles IO)")
+	fs.IntVar(&config.WriteBufferSize, "write-buffer-size", defaultWriteBufferSize, "buffer size in bytes for msgpack, CSV and NDJSON output")
+	fs.BoolVar(&config.Typed, "typed", true, "decode records of known lexicons into their indigo types, others generically (false: decode all generically)")
+	fs.BoolVar(&config.Canonical, "canonical", false, "write records as canonical JSON (sorted keys, compact) for byte-stable diffs")
+}
+
+// Main runs the atproto-car-extractor command with os.Args, exiting the
+// process with its exit code.
+func Main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "unpack":
+			if err := runUnpack(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "version":
+			printVersion()
+			return
+		case "history":
+			if err := runHistory(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "reunpack":
+			if err := runReunpack(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			return
+		case "index":
+			if err := runIndex(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "frame":
+			if err := runFrame(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "queue":
+			if err := runQueue(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "compare":
+			if err := runCompare(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "doctor":
+			if err := runDoctor(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "extract":
+			// an explicit name for the default command
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
+	config := Config{
+		DownloadBlobs: os.Getenv("DOWNLOAD_BLOBS") == "true",
+		CarsDir:       "cars",
+		RecordsDir:    "records",
+		DIDsFile:      "",
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [extract] [flags] <dids-file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.BoolVar(&config.Events, "events", false, "emit JSON lifecycle events to stdout (logs go to stderr)")
+	flag.BoolVar(&config.EventRecords, "events-records", false, "include a record-written event for every record (with -events)")
+	flag.StringVar(&config.DedupReport, "dedup-report", "", "write a report of CIDs repeated across repos to this path (e.g. dedup_report.json)")
+	flag.StringVar(&config.WebhookURL, "webhook", os.Getenv("WEBHOOK_URL"), "POST a JSON summary to this URL after each repo")
+	flag.BoolVar(&config.CompressCars, "compress-cars", false, "store downloaded CAR files gzip-compressed as <did>.car.gz")
+	flag.IntVar(&config.Concurrency, "concurrency", 1, "number of repos to process concurrently")
+	flag.IntVar(&config.FollowDepth, "follow-depth", 0, "also extract the accounts each repo follows, reposts or mentions, up to this many steps from the DIDs list")
+	flag.IntVar(&config.MaxDIDs, "max-dids", DefaultMaxDIDs, "with -follow-depth, stop following references once this many accounts are known")
+	flag.StringVar(&config.Schedule, "schedule", ScheduleList, "order to process repos in: list (the DIDs list order) or largest-first (by estimated CAR size)")
+	byteSizeVar(flag.CommandLine, &config.MaxBandwidth, "max-bandwidth", "cap the combined download rate from PDSes, per second (e.g. 10MB or 512KiB)")
+	flag.IntVar(&config.PerHost, "per-host", 0, "max repos in flight per PDS host (0 = no limit)")
+	addUnpackFlags(flag.CommandLine, &config)
+	flag.BoolVar(&config.StrictBlobs, "strict-blobs", false, "fail the repo if a listed blob is missing on the PDS, instead of skipping it with a warning")
+	flag.Int64Var(&config.MaxBlobBytes, "max-blob-bytes", 0, "skip blobs larger than this many bytes (0 = no limit)")
+	flag.Int64Var(&config.MinBlobBytes, "min-blob-bytes", 0, "skip blobs smaller than this many bytes (0 = no limit)")
+	flag.BoolVar(&config.NameByHandle, "name-by-handle", false, "name records directories by handle instead of DID (the DID is kept in _identity.json)")
+	flag.StringVar(&config.AuthIdentifier, "auth-identifier", os.Getenv("ATP_AUTH_IDENTIFIER"), "handle or DID to log in as (password from ATP_AUTH_PASSWORD)")
+	flag.StringVar(&config.ReportFormat, "report-format", ReportText, "end-of-run summary format: text or json")
+	flag.StringVar(&config.ReportFile, "report-file", "", "write the end-of-run summary to this file instead of the terminal")
+	flag.BoolVar(&config.CarsOnly, "cars-only", false, "only download CAR files; don't unpack records or fetch blobs")
+	flag.BoolVar(&config.ListBlobsOnly, "list-blobs-only", false, "only list each repo's blob CIDs into <records>/<did>/_blobs.txt; download nothing")
+	flag.BoolVar(&config.RecordLevel, "record-level", false, "for at:// entries of the DIDs file, fetch just those collections or records via listRecords/getRecord instead of the CAR (no MST verification)")
+	flag.StringVar(&config.OrderedOutput, "ordered-output", "", "write all repos as NDJSON to this one file in DIDs-file order, however the workers finish")
+	flag.IntVar(&config.BreakerThreshold, "breaker-threshold", 0, "skip a PDS host's remaining repos after this many consecutive failures (0 = off)")
+	flag.DurationVar(&config.BreakerCooldown, "breaker-cooldown", 0, "retry a broken host after this long (0 = never during this run)")
+	flag.BoolVar(&config.Provenance, "provenance", false, "write a provenance.json sidecar for each completed repo")
+	flag.BoolVar(&config.SummaryMD, "summary-md", false, "write a SUMMARY.md describing each completed repo for people browsing the archive")
+	flag.BoolVar(&config.Git, "git", false, "commit the records directory to a git repository after the run")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	flag.IntVar(&config.BlobShardDepth, "blob-shard-depth", 0, "shard _blob into this many levels of 2-hex-char subdirectories (0 = flat)")
+	flag.BoolVar(&config.ShortBlobNames, "short-blob-names", false, "name blob files by a 16-character hash instead of the full CID, indexed in _blob/_index.json")
+	flag.BoolVar(&config.LocalBlobRefs, "local-blob-refs", false, "rewrite blob refs in written records to the relative paths of the downloaded blobs, keeping the original as originalRef")
+	flag.StringVar(&config.BlobStore, "blob-store", "", "store every repo's blobs once in this shared directory instead of each repo's _blob")
+	flag.IntVar(&config.SeenFilter, "seen-filter", 0, "track seen CIDs in a bloom filter sized for this many distinct CIDs instead of exactly (approximate, bounded memory)")
+	flag.Float64Var(&config.SeenFilterFP, "seen-filter-fp", 0.001, "false-positive rate of the -seen-filter bloom filter")
+	flag.StringVar(&config.FromCars, "from-cars", "", "extract every CAR file in this directory instead of fetching repos, without any network access")
+	flag.StringVar(&config.FromList, "from-list", "", "extract the members of this app.bsky.graph.list or starterpack at:// URI")
+	flag.StringVar(&config.DIDsCSV, "dids-csv", "", "also extract the DIDs in this did,handle CSV, taking its handles as given instead of resolving them")
+	flag.StringVar(&config.AppView, "appview", DefaultAppView, "AppView host used to expand -from-list")
+	flag.BoolVar(&config.Preflight, "preflight", false, "check which PDS hosts are reachable, then exit without extracting")
+	flag.StringVar(&config.PostCommand, "post-cmd", "", "run this shell command after each repo completes (sees REPO_DID, REPO_HANDLE, RECORDS_DIR, CAR_PATH)")
+	flag.StringVar(&config.Index, "index", "", "record every processed account in this archive index database (see the index subcommand)")
+	ageVar(flag.CommandLine, &config.RefreshOlderThan, "refresh-older-than", "with -index, only process accounts last archived longer ago than this (e.g. 7d or 36h)")
+	flag.StringVar(&config.ForcePDS, "pds", "", "fetch repos and blobs from this host (e.g. https://bsky.network) instead of each account's PDS")
+	flag.StringVar(&config.Queue, "queue", "", "claim DIDs from this shared SQLite work queue (see the queue subcommand) instead of only the DIDs file")
+	flag.DurationVar(&config.QueueLease, "queue-lease", 10*time.Minute, "how long a -queue claim survives without renewal before other workers may take it over")
+	flag.BoolVar(&config.RecordSeq, "record-seq", false, "write _seq.json with the PDS's latest commit and the relay's firehose seq at extraction time")
+	flag.StringVar(&config.SeqRelay, "seq-relay", DefaultSeqRelay, "relay whose firehose seq -record-seq records")
+	flag.BoolVar(&config.VerifySignatures, "verify-signatures", false, "check each commit signature against the DID document's signing key, recording the result in _commit.json")
+	flag.BoolVar(&config.Force, "force", false, "overwrite archived repos even when the PDS serves an older rev than was archived")
+	flag.StringVar(&config.RecordPlugin, "record-plugin", "", "load this Go plugin (.so) and pass every record to its HandleRecord before writing")
+	flag.BoolVar(&config.VerifyResume, "verify-resume", false, "with -car-cache, check a cached CAR against its stored SHA-256 (or parse it) before reusing it, downloading it again if damaged")
+	flag.StringVar(&config.CarCache, "car-cache", "", "keep each repo's last CAR in this directory by rev, and reuse it instead of downloading while the PDS reports the same rev")
+	flag.StringVar(&config.Relay, "relay", "", "fetch the repo from this relay (e.g. https://bsky.network) when an account's DID document has no PDS")
+	flag.StringVar(&config.IncludeLabels, "include-labels", "", "save the labels this labeler DID applied to each account and its records as _labels.json")
+	flag.BoolVar(&config.IncludeAccountData, "include-account-data", false, "with -auth-identifier, also save the logged-in account's preferences and mutes under _account/")
+	flag.BoolVar(&config.TUI, "tui", false, "show a live progress dashboard instead of log lines (needs a terminal)")
+	flag.IntVar(&config.RetryFailedPasses, "retry-failed-passes", 0, "at the end of the run, extract the repos that failed with transient errors again, up to this many times")
+	flag.StringVar(&config.ProgressFile, "progress-file", "", "rewrite this JSON file every few seconds with the run's progress, for external monitoring")
+	flag.StringVar(&config.LogFile, "log-file", "", "write progress logs to this file (default extract.log with -tui)")
+	flag.StringVar(&config.DIDFilter, "did-filter", "", "only process lines of the DIDs file matching this regular expression")
+	flag.StringVar(&config.DIDMethod, "did-method", "", "only process DIDs of this method (plc or web)")
+	didListVar(flag.CommandLine, &config.OnlyDIDs, "only-did", "only process this DID of the loaded list (repeatable)")
+	didListVar(flag.CommandLine, &config.SkipDIDs, "skip-did", "don't process this DID (repeatable)")
+	flag.Func("collections", "only extract these comma-separated collections (e.g. app.bsky.feed.post,app.bsky.feed.like)", func(s string) error {
+		c, err := parseCollections(s)
+		config.Collections = append(config.Collections, c...)
+		return err
+	})
+	flag.StringVar(&config.CollectionsFile, "collections-file", "", "only extract the collections listed in this file, one per line")
+	flag.BoolVar(&config.CollectionsAuto, "collections-auto", false, "list the collections of a sample of the repos and ask which to extract (without a terminal, write them to -collections-file to edit)")
+	flag.IntVar(&config.CollectionsSample, "collections-sample", 5, "with -collections-auto, how many repos to sample")
+	flag.StringVar(&config.PDSDataDir, "pds-data", "", "read repos and blobs from this local PDS data directory (PDS_DATA_DIRECTORY) instead of the network")
+	configFile := flag.String("config", "", "read flag values from this YAML file (flags and environment variables override it)")
+	flag.Parse()
+	if *showVersion {
+		printVersion()
+		return
+	}
+	if *configFile != "" {
+		if err := applyConfigFile(flag.CommandLine, *configFile, &config); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	config.AuthPassword = os.Getenv("ATP_AUTH_PASSWORD")
+
+	// Check command line args first
+	if flag.NArg() > 0 {
+		config.DIDsFile = flag.Arg(0)
+	} else if env := os.Getenv("DIDS_FILE"); env != "" {
+		config.DIDsFile = env
+	}
+
+	if config.DIDsFile == "" && config.FromList == "" && config.DIDsCSV == "" && config.Queue == "" && config.FromCars == "" {
+		fmt.Fprintf(os.Stderr, "error: Please provide DIDs file path as argument, set DIDS_FILE environment variable, or use -from-list, -dids-csv, -queue or -from-cars\n")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if config.FromCars != "" {
+		if conflicts := offlineConflicts(config); len(conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "error: -from-cars reads only local CARs and can't be combined with %s\n", strings.Join(conflicts, ", "))
+			os.Exit(exitUsage)
+		}
+	}
+
+	if config.ReportFormat != ReportText && config.ReportFormat != ReportJSON {
+		fmt.Fprintf(os.Stderr, "error: -report-format must be %q or %q\n", ReportText, ReportJSON)
+		os.Exit(exitUsage)
+	}
+
+	if !validFormat(config.Format) {
+		fmt.Fprintf(os.Stderr, "error: unknown output format %q\n", config.Format)
+		os.Exit(exitUsage)
+	}
+	if err := checkOutputNames(config); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if _, err := loadJSONLDContext(config.JSONLDContext); err != nil {
+		fmt.Fprintf(os.Stderr, "error: -jsonld-context: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if config.ForcePDS != "" && !strings.HasPrefix(config.ForcePDS, "http://") && !strings.HasPrefix(config.ForcePDS, "https://") {
+		fmt.Fprintf(os.Stderr, "error: -pds must be an http:// or https:// URL\n")
+		os.Exit(exitUsage)
+	}
+	if config.RecordSeq && !strings.HasPrefix(config.SeqRelay, "http://") && !strings.HasPrefix(config.SeqRelay, "https://") {
+		fmt.Fprintf(os.Stderr, "error: -seq-relay must be an http:// or https:// URL\n")
+		os.Exit(exitUsage)
+	}
+	if config.Relay != "" && !strings.HasPrefix(config.Relay, "http://") && !strings.HasPrefix(config.Relay, "https://") {
+		fmt.Fprintf(os.Stderr, "error: -relay must be an http:// or https:// URL\n")
+		os.Exit(exitUsage)
+	}
+
+	if config.SinkURL != "" && !validSinkURL(config.SinkURL) {
+		fmt.Fprintf(os.Stderr, "error: -sink must be an http:// or https:// URL\n")
+		os.Exit(exitUsage)
+	}
+
+	if _, err := parseCSVFields(config.Fields); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if config.CollectionsAuto && len(config.Collections) > 0 {
+		fmt.Fprintf(os.Stderr, "error: -collections-auto can't be combined with -collections\n")
+		os.Exit(exitUsage)
+	}
+	if config.CollectionsFile != "" && !config.CollectionsAuto {
+		c, err := readCollectionsFile(config.CollectionsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: -collections-file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		config.Collections = append(config.Collections, c...)
+	}
+	if _, err := newDIDLineFilter(config.DIDFilter, config.DIDMethod); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if _, err := parseFilter(config.Filter); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if config.CarsOnly && config.ListBlobsOnly {
+		fmt.Fprintf(os.Stderr, "error: -cars-only and -list-blobs-only can't be combined\n")
+		os.Exit(exitUsage)
+	}
+	if config.RecordLevel && config.CarsOnly {
+		fmt.Fprintf(os.Stderr, "error: -record-level fetches no CAR to keep with -cars-only\n")
+		os.Exit(exitUsage)
+	}
+	if config.OrderedOutput != "" && (config.CarsOnly || config.ListBlobsOnly) {
+		fmt.Fprintf(os.Stderr, "error: -ordered-output has no records to write with -cars-only or -list-blobs-only\n")
+		os.Exit(exitUsage)
+	}
+	if ignored := streamIgnoredFlags(config); config.OrderedOutput != "" && len(ignored) > 0 {
+		fmt.Fprintf(os.Stderr, "error: -ordered-output writes NDJSON and can't be combined with %s\n", strings.Join(ignored, ", "))
+		os.Exit(exitUsage)
+	}
+	if config.Queue != "" && config.OrderedOutput != "" {
+		fmt.Fprintf(os.Stderr, "error: -ordered-output needs a fixed DIDs list and can't be used with -queue\n")
+		os.Exit(exitUsage)
+	}
+	if config.Schedule != ScheduleList && config.Schedule != ScheduleLargestFirst {
+		fmt.Fprintf(os.Stderr, "error: -schedule must be %q or %q\n", ScheduleList, ScheduleLargestFirst)
+		os.Exit(exitUsage)
+	}
+	if config.Queue != "" && config.Schedule != ScheduleList {
+		fmt.Fprintf(os.Stderr, "error: -schedule %s needs a fixed DIDs list and can't be used with -queue\n", config.Schedule)
+		os.Exit(exitUsage)
+	}
+	if config.FollowDepth < 0 || config.MaxDIDs < 1 {
+		fmt.Fprintf(os.Stderr, "error: -follow-depth can't be negative and -max-dids must be at least 1\n")
+		os.Exit(exitUsage)
+	}
+	if config.FollowDepth > 0 && (config.Queue != "" || config.Schedule != ScheduleList) {
+		fmt.Fprintf(os.Stderr, "error: -follow-depth can't be combined with -queue or -schedule %s\n", ScheduleLargestFirst)
+		os.Exit(exitUsage)
+	}
+	if config.VerifyResume && config.CarCache == "" {
+		fmt.Fprintf(os.Stderr, "error: -verify-resume checks the CARs of -car-cache and needs it\n")
+		os.Exit(exitUsage)
+	}
+	if config.RetryFailedPasses < 0 {
+		fmt.Fprintf(os.Stderr, "error: -retry-failed-passes can't be negative\n")
+		os.Exit(exitUsage)
+	}
+	if config.RetryFailedPasses > 0 && (config.Queue != "" || config.OrderedOutput != "") {
+		fmt.Fprintf(os.Stderr, "error: -retry-failed-passes can't be combined with -queue (see queue -retry-failed) or -ordered-output\n")
+		os.Exit(exitUsage)
+	}
+	if config.Queue != "" && config.QueueLease < 10*time.Second {
+		fmt.Fprintf(os.Stderr, "error: -queue-lease must be at least 10s\n")
+		os.Exit(exitUsage)
+	}
+	if config.BlobStore != "" && config.ShortBlobNames {
+		fmt.Fprintf(os.Stderr, "error: -short-blob-names keeps a per-repo index and can't be used with -blob-store\n")
+		os.Exit(exitUsage)
+	}
+	if config.LocalBlobRefs && !config.DownloadBlobs {
+		fmt.Fprintf(os.Stderr, "error: -local-blob-refs needs blob downloads (DOWNLOAD_BLOBS=true)\n")
+		os.Exit(exitUsage)
+	}
+	if config.SeenFilter < 0 || config.SeenFilterFP <= 0 || config.SeenFilterFP >= 1 {
+		fmt.Fprintf(os.Stderr, "error: -seen-filter can't be negative and -seen-filter-fp must be between 0 and 1\n")
+		os.Exit(exitUsage)
+	}
+
+	if config.TUI && config.Events {
+		fmt.Fprintf(os.Stderr, "error: -tui and -events both need stdout\n")
+		os.Exit(exitUsage)
+	}
+	if config.TUI && !isTerminal(os.Stdout) {
+		logf("Warning: stdout is not a terminal; ignoring -tui\n")
+		config.TUI = false
+	}
+	if config.TUI && config.LogFile == "" {
+		config.LogFile = "extract.log"
+	}
+	if config.LogFile != "" {
+		f, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		defer f.Close()
+		logw = f
+		if config.TUI {
+			dash = newDashboard(os.Stdout, config.Concurrency)
+		}
+	}
+
+	config, done, err := newRun(context.Background(), config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	defer done()
+
+	if err := run(config); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+func run(config Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// a second interrupt kills the process instead of waiting for workers
+	context.AfterFunc(ctx, stop)
+	started := time.Now()
+	var dids []string
+	if config.DIDsFile != "" {
+		fileDIDs, err := getActivatedDIDs(ctx, config)
+		if err != nil {
+			return usageError(fmt.Errorf("failed to get DIDs from file: %w", err))
+		}
+		dids, config.Scopes = scopeEntries(fileDIDs)
+	}
+	if config.DIDsCSV != "" {
+		csvDIDs, handles, err := readHandleCSV(config.DIDsCSV)
+		if err != nil {
+			return usageError(fmt.Errorf("failed to get DIDs from CSV: %w", err))
+		}
+		dids = appendNewDIDs(dids, csvDIDs)
+		config.KnownHandles = handles
+	}
+	if config.FromList != "" {
+		members, err := listMemberDIDs(ctx, config.AppView, config.FromList)
+		if err != nil {
+			return usageError(fmt.Errorf("failed to get DIDs from list: %w", err))
+		}
+		logf("Found %d members in %s\n", len(members), config.FromList)
+		dids = appendNewDIDs(dids, members)
+	}
+	if config.FromCars != "" {
+		carDIDs, cars, err := scanCarsDir(config.FromCars, config.Concurrency)
+		if err != nil {
+			return usageError(fmt.Errorf("failed to read -from-cars: %w", err))
+		}
+		dids = carDIDs
+		config.LocalCars = cars
+	}
+	dids = selectDIDs(dids, config.OnlyDIDs, config.SkipDIDs)
+	if config.CollectionsAuto {
+		picked, err := chooseCollections(ctx, dids, config)
+		if err != nil {
+			return fmt.Errorf("failed to discover collections: %w", err)
+		}
+		if picked == nil {
+			return nil
+		}
+		logf("Extracting %s\n", strings.Join(picked, ", "))
+		config.Collections = picked
+	}
+
+	if config.RefreshOlderThan > 0 {
+		stale, err := archiveIndex.staleDIDs(dids, config.RefreshOlderThan, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to read index: %w", err)
+		}
+		logf("Skipping %d accounts archived within %s\n", len(dids)-len(stale), config.RefreshOlderThan)
+		dids = stale
+	}
+
+	if config.Preflight {
+		if down := runPreflight(ctx, config, dids); down > 0 {
+			return fmt.Errorf("%d PDS hosts are unreachable", down)
+		}
+		return nil
+	}
+
+	if err := ensureDirectories(config); err != nil {
+		return usageError(err)
+	}
+	ordered, err := openOrderedOutput(config.OrderedOutput, config)
+	if err != nil {
+		return usageError(err)
+	}
+
+	var results <-chan RepoResult
+	total := len(dids)
+	if config.Queue != "" {
+		q, err := openQueueRun(config, dids)
+		if err != nil {
+			return usageError(err)
+		}
+		defer q.close()
+		counts, err := q.counts()
+		if err != nil {
+			return err
+		}
+		total = counts[queuePending] + counts[queueClaimed]
+		results = extractQueue(ctx, config, q)
+	} else if config.FollowDepth > 0 {
+		results = extractCrawl(ctx, config, dids)
+	} else {
+		results = extractAll(ctx, config, dids)
+	}
+
+	// each result is logged and reported by the worker that produced it;
+	// here we only collect them for the summary
+	report := RunReport{Total: total}
+	updateIndex := func(res RepoResult) {
+		if err := archiveIndex.update(res, time.Now()); err != nil {
+			logf("Warning: failed to update index for %s: %v\n", res.DID, err)
+		}
+	}
+	dash.start(total)
+	for res := range results {
+		ordered.add(res)
+		res.stream = nil
+		report.add(res)
+		updateIndex(res)
+	}
+	report = retryFailedPasses(ctx, config, report, updateIndex)
+	dash.close()
+	if config.Queue != "" || config.FollowDepth > 0 {
+		// other workers may have taken some of what was left, or the crawl
+		// found more
+		report.Total = len(report.Repos)
+	}
+	report.Interrupted = ctx.Err() != nil
+	report.BrokenHosts = breaker.brokenHosts()
+	if err := writeRunReport(&report, config); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := ordered.close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", config.OrderedOutput, err)
+	}
+
+	if config.Git && !config.CarsOnly {
+		if err := commitRecordsToGit(config.RecordsDir, &report, started); err != nil {
+			return fmt.Errorf("failed to commit records: %w", err)
+		}
+	}
+
+	if err := dedup.writeReport(config.DedupReport); err != nil {
+		return fmt.Errorf("failed to write dedup report: %w", err)
+	}
+
+	return outcomeError(&report)
+}
+
+// Repo outcome statuses reported in RepoResult.
+const (
+	StatusOK          = "ok"
+	StatusError       = "error"
+	StatusUnsupported = "unsupported"
+	StatusLocked      = "locked"
+	// StatusRecordError is a repo that downloaded but had a record that
+	// couldn't be read or written, with -strict-records.
+	StatusRecordError = "record_error"
+	// StatusRevRegression is a repo left alone because its PDS served an
+	// older rev than the archived one.
+	StatusRevRegression = "rev_regression"
+)
+
+// RepoResult describes what happened to one entry of the DIDs file.
+type RepoResult struct {
+	DID            string `json:"did"`
+	Handle         string `json:"handle,omitempty"`
+	DeclaredHandle string `json:"declared_handle,omitempty"`
+	HandleVerified bool   `json:"handle_verified,omitempty"`
+	PDS            string `json:"pds,omitempty"`
+	Rev            string `json:"rev,omitempty"`
+	Status         string `json:"status"`
+	Error          string `json:"error,omitempty"`
+	Records        int    `json:"records"`
+	Blobs          int    `json:"blobs"`
+	Empty          bool   `json:"empty,omitempty"`
+	CarPath        string `json:"car_path,omitempty"`
+	RecordsPath    string `json:"records_path,omitempty"`
+	PostCmdError   string `json:"post_cmd_error,omitempty"`
+	// SignatureValid is the commit signature check, with -verify-signatures
+	SignatureValid *bool `json:"signature_valid,omitempty"`
+	// MissingBlobs are blobs the repo listed that the PDS then couldn't
+	// find, skipped without -strict-blobs
+	MissingBlobs []string `json:"missing_blobs,omitempty"`
+
+	// index is the repo's position in the DIDs list, and stream its NDJSON
+	// with -ordered-output. refs are the accounts it refers to, for
+	// -follow-depth. given is the entry as listed, before resolving, and
+	// transient whether it failed in a way worth retrying. hostFree is set
+	// with errHostBusy, for runWorkers to wait on.
+	index     int
+	stream    []byte
+	refs      []string
+	given     string
+	transient bool
+	hostFree  <-chan struct{}
+}
+
+// repoState is what processRepo works out about one repo on the way to
+// writing it, for the functions that fetch and write it. The zero value
+// is a whole repo with no archive to compare against.
+type repoState struct {
+	// scope is the repo's entry in Config.Scopes, or else
+	// Config.Collections; empty means everything
+	scope []string
+	// archivedRev is the rev of the repo as already archived
+	archivedRev string
+	// signature is the check of the commit signature, with
+	// -verify-signatures
+	signature *CommitSignature
+	// unsigned is set when the commit was made up locally by a
+	// -record-level fetch, so it isn't written out as if it were signed
+	unsigned bool
+}
+
+// writesCommit reports whether the repo's commit is written out: the
+// _commit.json file, or the commit line of a stream.
+func (st repoState) writesCommit(config Config) bool {
+	return !config.SkipCommitFile && !st.unsigned
+}
+
+// processRepo downloads and unpacks one repo. The returned result is filled
+// in as far as processing got, even when an error is returned.
+func processRepo(ctx context.Context, did string, config Config) (RepoResult, error) {
+	res := RepoResult{DID: did}
+	st := repoState{scope: config.Scopes[did]}
+	if len(st.scope) == 0 {
+		st.scope = config.Collections
+	}
+	if carPath, ok := config.LocalCars[did]; ok {
+		return processLocalCar(ctx, did, carPath, st, config)
+	}
+
+	// Parse DID
+	atid, err := syntax.ParseAtIdentifier(did)
+	if err != nil {
+		return res, err
+	}
+	if atid.IsDID() {
+		if err := checkDIDMethod(atid.String()); err != nil {
+			return res, err
+		}
+	}
+
+	// Look up the DID and PDS
+	logf("Processing: %s\n", atid.String())
+	events.emit(Event{Type: EventRepoStart, DID: did})
+	var ident *identity.Identity
+	if handle, ok := config.KnownHandles[did]; ok && atid.IsDID() {
+		ident, err = lookupKnownHandle(ctx, syntax.DID(did), handle)
+	} else {
+		ident, err = identity.DefaultDirectory().Lookup(ctx, *atid)
+	}
+	if err != nil {
+		return res, err
+	}
+	res.DID = ident.DID.String()
+	res.Handle = ident.Handle.String()
+	res.PDS = ident.PDSEndpoint()
+	dash.resolved(did, res.DID)
+	res.DeclaredHandle, res.HandleVerified = verifyHandle(ident)
+	if !res.HandleVerified {
+		if res.DeclaredHandle == "" {
+			logf("Warning: %s declares no handle\n", res.DID)
+		} else {
+			logf("Warning: handle %s does not resolve back to %s\n", res.DeclaredHandle, res.DID)
+		}
+	}
+
+	host := pdsHost(ident, config)
+	if relayFallback(ident, config) {
+		logf("Info: the DID document of %s lists no PDS; fetching its repo from relay %s\n", res.DID, host)
+	}
+	if err := breaker.allow(host); err != nil {
+		return res, err
+	}
+
+	// a repo set aside for a busy host and picked up again after ctx was
+	// cancelled stops here
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	release, free := hostLimits.tryAcquire(host)
+	if release == nil {
+		res.hostFree = free
+		return res, errHostBusy
+	}
+	defer release()
+
+	carPath := filepath.Join(config.CarsDir, carFileName(ident.DID.String(), config))
+	recordsPath := filepath.Join(config.RecordsDir, ident.DID.String())
+	if config.NameByHandle {
+		recordsPath = filepath.Join(config.RecordsDir, handleNames.dirName(config.RecordsDir, ident))
+	}
+
+	// Keep other workers and processes out of this repo's output. The lock
+	// goes in the records directory only if something is written there, so
+	// -cars-only and -ordered-output don't leave an empty one behind.
+	lockPath := carPath + ".lock"
+	if writesRecordsDir(config) {
+		lockPath = filepath.Join(recordsPath, "_lock")
+	}
+	unlock, err := lockRepoOutput(lockPath)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	if config.ListBlobsOnly {
+		res.RecordsPath = recordsPath
+		res.Blobs, err = listBlobs(ctx, ident, recordsPath, config)
+		breaker.record(host, err)
+		if err != nil {
+			return res, err
+		}
+		events.emit(Event{Type: EventRepoDone, DID: ident.DID.String(), Handle: ident.Handle.String(), Path: recordsPath})
+		return res, nil
+	}
+
+	// note where the PDS and the firehose are before fetching anything
+	var seqPos *SeqPosition
+	if config.RecordSeq {
+		pos, err := recordSeqPosition(ctx, host, res.DID, config.SeqRelay)
+		if err != nil {
+			logf("Warning: failed to record the firehose position for %s: %v\n", res.DID, err)
+		} else {
+			seqPos = &pos
+		}
+	}
+
+	var r *repo.Repo
+	root := cid.Undef
+	if scopedFetch(st, config) {
+		// Fetch only the records in scope
+		logf("Fetching %s of %s from %s\n", strings.Join(st.scope, ", "), ident.DID, host)
+		r, err = fetchScopedRepo(ctx, ident, st.scope, config)
+		breaker.record(host, err)
+		if err != nil {
+			return res, err
+		}
+		st.unsigned = true
+	} else {
+		// Download repo, refusing one older than the archive
+		st.archivedRev = archivedRev(filepath.Join(recordsPath, commitFileName(config)), res.DID)
+		err = downloadRepo(ctx, ident, carPath, st.archivedRev, config)
+		breaker.record(host, err)
+		var regression *RevRegressionError
+		if errors.As(err, &regression) {
+			recordRevRegression(recordsPath, res.DID, host, RevRegression{ArchivedRev: regression.Archived, ServedRev: regression.Served})
+		}
+		if err != nil {
+			return res, err
+		}
+		res.CarPath = carPath
+
+		if config.CarsOnly {
+			dedup.addRepo()
+			events.emit(Event{Type: EventRepoDone, DID: ident.DID.String(), Handle: ident.Handle.String(), Path: carPath})
+			return res, nil
+		}
+
+		r, root, err = readCarRoot(ctx, carPath)
+		if err != nil {
+			return res, err
+		}
+	}
+	res.Rev = r.SignedCommit().Rev
+	if config.FollowDepth > 0 {
+		res.refs, err = repoRefs(ctx, r)
+		if err != nil {
+			logf("Warning: failed to scan %s for accounts to follow: %v\n", res.DID, err)
+		}
+	}
+	if config.VerifySignatures && !st.unsigned {
+		st.signature = verifyCommitSignature(ident, r.SignedCommit())
+		res.SignatureValid = &st.signature.Valid
+		if !st.signature.Valid {
+			logf("Warning: commit signature of %s doesn't verify: %s\n", res.DID, st.signature.Error)
+		}
+	}
+	if config.Force && st.archivedRev != "" && res.Rev != "" && res.Rev < st.archivedRev {
+		recordRevRegression(recordsPath, res.DID, host, RevRegression{ArchivedRev: st.archivedRev, ServedRev: res.Rev, Forced: true})
+	}
+
+	// record counts by collection for -summary-md
+	var collections map[string]int
+	if config.SummaryMD {
+		collections = map[string]int{}
+	}
+	if config.OrderedOutput != "" {
+		res.Records, res.stream, err = streamRecords(ctx, r, st, config)
+		if err != nil {
+			return res, err
+		}
+		res.Empty = res.Records == 0
+	} else {
+		// Unpack records
+		if err := writeIdentityFile(recordsPath, ident); err != nil {
+			return res, err
+		}
+		res.RecordsPath = recordsPath
+		res.Records, err = unpackRepo(ctx, r, root, recordsPath, st, config, collections)
+		if errors.Is(err, ErrEmptyRepo) {
+			logf("Info: %s has no records\n", res.DID)
+			res.Empty = true
+		} else if err != nil {
+			return res, err
+		}
+
+		if config.IncludeAccountData {
+			saved, err := writeAccountData(ctx, res.DID, recordsPath)
+			if err != nil {
+				return res, fmt.Errorf("failed to save account data: %w", err)
+			}
+			if saved {
+				logf("Saved account data for %s\n", res.DID)
+			}
+		}
+
+		if seqPos != nil {
+			if err := writeSeqFile(recordsPath, *seqPos, res.Rev); err != nil {
+				logf("Warning: failed to write _seq.json for %s: %v\n", res.DID, err)
+			}
+		}
+
+		// labels are extra context; a labeler failure doesn't fail the repo
+		if n, err := labels.writeLabels(ctx, res.DID, recordsPath); err != nil {
+			logf("Warning: failed to save labels for %s: %v\n", res.DID, err)
+		} else if n > 0 {
+			logf("Saved %d labels for %s\n", n, res.DID)
+		}
+	}
+
+	// Handle blobs if enabled
+	if config.DownloadBlobs {
+		res.Blobs, res.MissingBlobs, err = downloadBlobs(ctx, ident, recordsPath, config)
+		breaker.record(host, err)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	// a -record-level fetch of a scoped account leaves no CAR to describe
+	if config.Provenance && res.RecordsPath != "" && res.CarPath != "" {
+		if err := writeProvenance(ctx, ident, host, res); err != nil {
+			return res, fmt.Errorf("failed to write provenance: %w", err)
+		}
+	}
+	if config.SummaryMD && res.RecordsPath != "" {
+		if err := writeSummary(res, collections, st.scope, config); err != nil {
+			return res, fmt.Errorf("failed to write summary: %w", err)
+		}
+	}
+
+	dedup.addRepo()
+	events.emit(Event{Type: EventRepoDone, DID: ident.DID.String(), Handle: ident.Handle.String(), Path: recordsPath, Empty: res.Empty})
+	return res, nil
+}
+
+// writesRecordsDir reports whether processing a repo writes into its
+// records directory: always, except with -cars-only and with
+// -ordered-output unless blobs are downloaded into the records directory.
+func writesRecordsDir(config Config) bool {
+	if config.CarsOnly {
+		return false
+	}
+	return config.OrderedOutput == "" || (config.DownloadBlobs && config.BlobStore == "")
+}
+
+// pdsHost returns the host to fetch an account's repo and blobs from:
+// config.ForcePDS when set, otherwise the PDS in its DID document, or
+// config.Relay when the document has none.
+func pdsHost(ident *identity.Identity, config Config) string {
+	if config.ForcePDS != "" {
+		return config.ForcePDS
+	}
+	if relayFallback(ident, config) {
+		return config.Relay
+	}
+	return ident.PDSEndpoint()
+}
+
+// relayFallback reports whether an account's repo comes from -relay because
+// its DID document has no PDS.
+func relayFallback(ident *identity.Identity, config Config) bool {
+	return config.ForcePDS == "" && config.Relay != "" && ident.PDSEndpoint() == ""
+}
+
+// downloadRepo writes an account's repo to carPath, from its PDS, -pds-data
+// or -car-cache, refusing one at an older rev than archivedRev.
+func downloadRepo(ctx context.Context, ident *identity.Identity, carPath, archivedRev string, config Config) error {
+	if config.PDSDataDir != "" {
+		logf("Reading %s from %s to: %s\n", ident.DID, config.PDSDataDir, carPath)
+		repoBytes, err := localRepoCar(ctx, config.PDSDataDir, ident.DID.String())
+		if err != nil {
+			return err
+		}
+		if err := checkServedRev(repoBytes, archivedRev, config); err != nil {
+			return err
+		}
+		if err := writeCarFile(carPath, repoBytes); err != nil {
+			return err
+		}
+		events.emit(Event{Type: EventCarDownloaded, DID: ident.DID.String(), Path: carPath, Bytes: len(repoBytes)})
+		return nil
+	}
+
+	host := pdsHost(ident, config)
+	if host == "" {
+		return fmt.Errorf("no PDS endpoint for identity")
+	}
+
+	if b, rev, ok := cars.latest(ctx, host, ident.DID.String()); ok {
+		if err := checkServedRev(b, archivedRev, config); err != nil {
+			return err
+		}
+		logf("Using cached CAR of %s at rev %s\n", ident.DID, rev)
+		return writeCarFile(carPath, b)
+	}
+
+	logf("Downloading from %s to: %s\n", host, carPath)
+	dash.downloading(ident.DID.String())
+	var repoBytes []byte
+	err := session.withClient(ctx, host, func(c *xrpc.Client) error {
+		var err error
+		repoBytes, err = fetchRepoCar(ctx, c, ident.DID.String(), carPath+".part")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := checkServedRev(repoBytes, archivedRev, config); err != nil {
+		return err
+	}
+	if err := writeCarFile(carPath, repoBytes); err != nil {
+		return err
+	}
+	if err := cars.put(ident.DID.String(), repoBytes); err != nil {
+		logf("Warning: failed to cache CAR of %s: %v\n", ident.DID, err)
+	}
+	events.emit(Event{Type: EventCarDownloaded, DID: ident.DID.String(), Path: carPath, Bytes: len(repoBytes)})
+	return nil
+}
+
+// UnsupportedDIDMethodError is returned for DIDs whose method can't host an
+// atproto repo.
+type UnsupportedDIDMethodError struct {
+	Method string
+}
+
+func (e *UnsupportedDIDMethodError) Error() string {
+	return fmt.Sprintf("unsupported DID method: did:%s (only did:plc and did:web are supported)", e.Method)
+}
+
+// RecordError is a record that failed to read, decode or encode while
+// unpacking with -strict-records.
+type RecordError struct {
+	Key string
+	Op  string
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("failed to %s record %s: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// skipBadRecord handles a record that failed op: it is skipped with a
+// warning, or with -strict-records fails the repo.
+func skipBadRecord(config Config, k, op string, err error) error {
+	if config.StrictRecords {
+		return &RecordError{Key: k, Op: op, Err: err}
+	}
+	logf("Warning: Failed to %s record %s: %v\n", op, k, err)
+	return nil
+}
+
+// checkDIDMethod rejects DIDs other than did:plc and did:web up front, since
+// resolving them fails with a much less helpful error.
+func checkDIDMethod(raw string) error {
+	did, err := syntax.ParseDID(raw)
+	if err != nil {
+		return err
+	}
+	switch did.Method() {
+	case "plc", "web":
+		return nil
+	default:
+		return &UnsupportedDIDMethodError{Method: did.Method()}
+	}
+}
+
+// ErrEmptyRepo is returned by unpackRepo when the repo holds no records
+// at all, such as for a freshly created account. The commit file is still
+// written.
+var ErrEmptyRepo = errors.New("repo has no records")
+
+// readCar loads a repo from a CAR file on disk.
+func readCar(ctx context.Context, carPath string) (*repo.Repo, error) {
+	r, _, err := readCarRoot(ctx, carPath)
+	return r, err
+}
+
+// readCarRoot is readCar, also returning the root CID from the CAR header.
+func readCarRoot(ctx context.Context, carPath string) (*repo.Repo, cid.Cid, error) {
+	fi, err := openCar(carPath)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	defer fi.Close()
+	payload, err := carPayload(fi)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	root, err := repo.IngestRepo(ctx, bs, payload)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	r, err := repo.OpenRepo(ctx, bs, root)
+	return r, root, err
 }
 
-func main() {
-	config := Config{
-		DownloadBlobs: os.Getenv("DOWNLOAD_BLOBS") == "true",
-		CarsDir:      "cars",
-		RecordsDir:   "records",
-		DIDsFile:     "",
-	}
+// unpackRepo writes the commit and records of an already-loaded repo under
+// recordsPath, returning the number of records written. root is the CAR
+// header's root CID, recorded in _commit.json.
+func unpackRepo(ctx context.Context, r *repo.Repo, root cid.Cid, recordsPath string, st repoState, config Config, collections map[string]int) (int, error) {
+	var err error
 
-	// Check command line args first
-	if len(os.Args) > 1 {
-		config.DIDsFile = os.Args[1]
-	} else {
-		config.DIDsFile = os.Getenv("DIDS_FILE")
-	}
+	// Get commit object
+	sc := r.SignedCommit()
+	logf("writing output to: %s\n", recordsPath)
 
-	if config.DIDsFile == "" {
-		fmt.Fprintf(os.Stderr, "error: Please provide DIDs file path as argument or set DIDS_FILE environment variable\n")
-		fmt.Fprintf(os.Stderr, "usage: %s <dids-file>\n", os.Args[0])
-		os.Exit(1)
+	// first the commit object as a meta file
+	if st.writesCommit(config) {
+		if err := writeCommitFile(filepath.Join(recordsPath, commitFileName(config)), sc, root, st.signature); err != nil {
+			return 0, err
+		}
 	}
 
-	if err := run(config); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+	var hw *highWater
+	if config.Incremental {
+		hw, err = loadHighWater(recordsPath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load high-water marks: %w", err)
+		}
 	}
-}
 
-func run(config Config) error {
-	if err := ensureDirectories(config); err != nil {
-		return err
+	// then all the actual records
+	count := 0
+	var cids map[string]string
+	if config.RecordCIDs {
+		cids = make(map[string]string)
 	}
-
-	ctx := context.Background()
-	dids, err := getActivatedDIDs(ctx, config.DIDsFile)
+	// written counts a record that was emitted, and lists it in
+	// _cids.json
+	written := func(k, collection string, v cid.Cid) {
+		count++
+		if collections != nil {
+			collections[collection]++
+		}
+		if cids != nil {
+			cids[k] = v.String()
+		}
+		hw.advance(k)
+	}
+	total := 0
+	filter, err := parseFilter(config.Filter)
+	if err != nil {
+		return 0, err
+	}
+	// set up whatever else can fail before opening the sink, so an error
+	// can't leave its file open
+	localBlobs, err := newLocalBlobRefs(recordsPath, config)
 	if err != nil {
-		return fmt.Errorf("failed to get DIDs from file: %w", err)
+		return 0, err
+	}
+	sink, err := newRecordSink(recordsPath, config)
+	if err != nil {
+		return 0, err
+	}
+	remote := newHTTPSink(ctx, config.SinkURL, sc.Did)
+	redact := newRedactor(config.DropFields, config.HashFields)
+	redacted := 0
+	paths := map[string]string{}
+	// collection directories already created, so MkdirAll runs once each
+	made := map[string]bool{}
+	var order []string
+	var uris []string
+	unverified := 0
+	var blobRefs blobRefIndex
+	if config.BlobRefs {
+		blobRefs = blobRefIndex{}
+	}
+	var stats *RepoStats
+	if config.Stats {
+		stats = newRepoStats(sc.Did, sc.Rev)
 	}
+	cutoff := recordCutoff(config)
+	suffix := recordSuffix(config)
+	badRecord := func(k, op string, err error) error {
+		hw.hold(k)
+		return skipBadRecord(config, k, op, err)
+	}
+	err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		total++
+		if config.SeqIndex {
+			order = append(order, k)
+		}
+		if !inScope(st.scope, k) {
+			return nil
+		}
+		if hw.seen(k) {
+			return nil
+		}
+		if tooOld(k, cutoff) {
+			hw.hold(k)
+			return nil
+		}
 
-	for _, did := range dids {
-		if err := processRepo(did, config); err != nil {
-			fmt.Fprintf(os.Stderr, "error processing %s: %v\n", did, err)
-			continue
+		blk, err := r.Blockstore().Get(ctx, v)
+		if err != nil {
+			return badRecord(k, "get", err)
 		}
-	}
+		rec, err := decodeRecord(blk.RawData(), config.Typed)
+		if err != nil {
+			return badRecord(k, "decode", err)
+		}
+		if ok, err := filter.match(rec); err != nil {
+			return badRecord(k, "filter", err)
+		} else if !ok {
+			return nil
+		}
+		value, n, err := redact.apply(rec)
+		if err != nil {
+			return badRecord(k, "redact", err)
+		}
+		redacted += n
 
-	return nil
-}
+		if config.RecordHandler != nil {
+			rc := RepoContext{DID: sc.Did, Rev: sc.Rev, RecordsPath: recordsPath}
+			if err := config.RecordHandler(ctx, rc, k, v, value); errors.Is(err, ErrDropRecord) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("record handler failed on %s: %w", k, err)
+			}
+		}
 
-func processRepo(did string, config Config) error {
-	ctx := context.Background()
-	
-	// Parse DID
-	atid, err := syntax.ParseAtIdentifier(did)
+		if config.VerifyOutput {
+			if err := verifyRecordCID(ctx, r, v); err != nil {
+				logf("Warning: Verification failed for %s: %v\n", k, err)
+				unverified++
+			}
+		}
+
+		if dedup != nil {
+			size, err := r.Blockstore().GetSize(ctx, v)
+			if err == nil {
+				dedup.addRecord(v.String(), int64(size))
+			}
+		}
+
+		collection, rkey, _ := strings.Cut(k, "/")
+		out := outRecord{
+			URI:        "at://" + sc.Did + "/" + k,
+			Collection: collection,
+			Rkey:       rkey,
+			CID:        v.String(),
+			Value:      value,
+		}
+		if config.URIList {
+			uris = append(uris, out.URI)
+		}
+		if err := blobRefs.add(out.URI, value); err != nil {
+			logf("Warning: Failed to scan record %s for blobs: %v\n", k, err)
+		}
+		stats.add(k, collection, value)
+		// sinks write their files in recordsPath, the JSON tree one
+		// directory per collection below it
+		fromDir := recordsPath
+		if sink == nil {
+			recPath, _ := recordFilePath(recordsPath, k)
+			fromDir = filepath.Dir(recPath)
+		}
+		if value, err = localBlobs.rewrite(value, fromDir); err != nil {
+			return badRecord(k, "rewrite", err)
+		}
+		out.Value = value
+		if err := remote.write(out); err != nil {
+			return err
+		}
+		if sink != nil {
+			if err := sink.write(out); err != nil {
+				return err
+			}
+			written(k, collection, v)
+			return nil
+		}
+
+		recPath, sanitized := recordFilePath(recordsPath, k)
+		if sanitized {
+			rel, _ := filepath.Rel(recordsPath, recPath)
+			paths[filepath.ToSlash(rel)+suffix] = k
+		}
+		logf("%s\n", recPath+suffix)
+		if dir := filepath.Dir(recPath); !made[dir] {
+			os.MkdirAll(dir, os.ModePerm)
+			made[dir] = true
+		}
+		recJson, err := encodeRecord(value, config)
+		if err != nil {
+			return badRecord(k, "marshal", err)
+		}
+		if config.OnlyChanged {
+			if existing, err := os.ReadFile(recPath + suffix); err == nil && bytes.Equal(existing, recJson) {
+				written(k, collection, v)
+				return nil
+			}
+		}
+		if err := os.WriteFile(recPath+suffix, recJson, 0666); err != nil {
+			return err
+		}
+		if config.VerifyOutput {
+			if err := verifyRecordFile(recPath+suffix, recJson); err != nil {
+				logf("Warning: Verification failed for %s: %v\n", k, err)
+				unverified++
+			}
+		}
+		written(k, collection, v)
+		events.emit(Event{Type: EventRecordWritten, DID: sc.Did, Path: recPath + suffix, CID: v.String(), Bytes: len(recJson)})
+
+		return nil
+	})
+	if sink != nil {
+		if cerr := sink.close(); err == nil {
+			err = cerr
+		}
+	}
 	if err != nil {
-		return err
+		return count, err
+	}
+	if err := remote.finish(sc.Rev); err != nil {
+		return count, err
+	}
+	if redact != nil {
+		logf("Redacted %d fields in %s\n", redacted, sc.Did)
+	}
+	if config.SeqIndex {
+		if err := writeOrderFile(recordsPath, order); err != nil {
+			return count, err
+		}
+	}
+	if config.URIList {
+		if err := writeURIList(recordsPath, uris); err != nil {
+			return count, err
+		}
 	}
+	if blobRefs != nil {
+		if err := blobRefs.write(recordsPath, writeBufferSize(config)); err != nil {
+			return count, err
+		}
+	}
+	if len(paths) > 0 {
+		if err := writePathsFile(recordsPath, paths); err != nil {
+			return count, err
+		}
+	}
+	if cids != nil {
+		if err := writeCIDsFile(recordsPath, cids); err != nil {
+			return count, err
+		}
+	}
+	if err := stats.write(recordsPath); err != nil {
+		return count, err
+	}
+	if config.IncludeMSTMeta {
+		positions, err := mstPositions(ctx, r)
+		if err != nil {
+			return count, fmt.Errorf("failed to walk MST: %w", err)
+		}
+		if err := writeMSTFile(recordsPath, positions); err != nil {
+			return count, err
+		}
+	}
+	if err := hw.save(recordsPath); err != nil {
+		return count, err
+	}
+	if total == 0 {
+		return 0, ErrEmptyRepo
+	}
+	if unverified > 0 {
+		return count, fmt.Errorf("%d records failed output verification", unverified)
+	}
+	return count, nil
+}
 
-	// Look up the DID and PDS
-	fmt.Printf("Processing: %s\n", atid.String())
-	dir := identity.DefaultDirectory()
-	ident, err := dir.Lookup(ctx, *atid)
+// writeCommitFile writes the signed commit object to commitPath, the
+// _commit.json of the repo's output directory, with the signature check if
+// sig is set.
+func writeCommitFile(commitPath string, sc repo.SignedCommit, root cid.Cid, sig *CommitSignature) error {
+	os.MkdirAll(filepath.Dir(commitPath), os.ModePerm)
+	cf := commitFile{SignedCommit: sc, RevTime: revTime(sc.Rev), CommitSignature: sig}
+	if root.Defined() {
+		cf.RootCID = root.String()
+	}
+	commitCID, err := signedCommitCID(sc)
 	if err != nil {
 		return err
 	}
-
-	// Download repo
-	carPath := filepath.Join(config.CarsDir, ident.DID.String()+".car")
-	if err := downloadRepo(ctx, ident, carPath); err != nil {
+	cf.CommitCID = commitCID.String()
+	recJson, err := json.MarshalIndent(cf, "", "  ")
+	if err != nil {
 		return err
 	}
+	return os.WriteFile(commitPath, recJson, 0666)
+}
 
-	// Unpack records
-	recordsPath := filepath.Join(config.RecordsDir, ident.DID.String())
-	if err := unpackRecords(ctx, carPath, recordsPath); err != nil {
-		return err
-	}
+// commitFile is the content of _commit.json: the signed commit plus the
+// time encoded in its rev.
+type commitFile struct {
+	repo.SignedCommit
+	RevTime string `json:"revTime,omitempty"`
 
-	// Handle blobs if enabled
-	if config.DownloadBlobs {
-		if err := downloadBlobs(ctx, ident, recordsPath); err != nil {
-			return err
-		}
-	}
+	// RootCID is the root named in the CAR header and CommitCID the CID
+	// of the commit as re-encoded here. They match for a well-formed repo.
+	RootCID   string `json:"rootCid,omitempty"`
+	CommitCID string `json:"commitCid"`
 
-	return nil
+	// with -verify-signatures
+	*CommitSignature
 }
 
-func downloadRepo(ctx context.Context, ident *identity.Identity, carPath string) error {
-	xrpcc := xrpc.Client{
-		Host: ident.PDSEndpoint(),
-	}
-	if xrpcc.Host == "" {
-		return fmt.Errorf("no PDS endpoint for identity")
+// signedCommitCID computes the dag-cbor CID of a signed commit.
+func signedCommitCID(sc repo.SignedCommit) (cid.Cid, error) {
+	var buf bytes.Buffer
+	if err := sc.MarshalCBOR(&buf); err != nil {
+		return cid.Undef, err
 	}
+	return cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum(buf.Bytes())
+}
 
-	fmt.Printf("Downloading from %s to: %s\n", xrpcc.Host, carPath)
-	repoBytes, err := comatproto.SyncGetRepo(ctx, &xrpcc, ident.DID.String(), "")
+// revTime decodes the timestamp in a rev TID as RFC 3339, or "" if rev
+// isn't a TID.
+func revTime(rev string) string {
+	tid, err := syntax.ParseTID(rev)
 	if err != nil {
-		return err
+		return ""
 	}
-	return os.WriteFile(carPath, repoBytes, 0666)
+	return tid.Time().UTC().Format(time.RFC3339Nano)
 }
 
-func unpackRecords(ctx context.Context, carPath, recordsPath string) error {
-	fi, err := os.Open(carPath)
+// writeCIDsFile writes the record key to CID mapping as _cids.json.
+func writeCIDsFile(recordsPath string, cids map[string]string) error {
+	b, err := json.MarshalIndent(cids, "", "  ")
 	if err != nil {
 		return err
 	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return os.WriteFile(filepath.Join(recordsPath, "_cids.json"), b, 0666)
+}
 
-	r, err := repo.ReadRepoFromCar(ctx, fi)
-	if err != nil {
-		return err
+// writeURIList writes the URIs of the extracted records as _uris.txt, one
+// per line in MST order.
+func writeURIList(recordsPath string, uris []string) error {
+	var b strings.Builder
+	for _, uri := range uris {
+		b.WriteString(uri)
+		b.WriteByte('\n')
 	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return os.WriteFile(filepath.Join(recordsPath, "_uris.txt"), []byte(b.String()), 0666)
+}
 
-	// Get commit object
-	sc := r.SignedCommit()
-	fmt.Printf("writing output to: %s\n", recordsPath)
-
-	// first the commit object as a meta file
-	commitPath := filepath.Join(recordsPath, "_commit")
-	os.MkdirAll(filepath.Dir(commitPath), os.ModePerm)
-	recJson, err := json.MarshalIndent(sc, "", "  ")
+// writeOrderFile writes the record keys in MST traversal order as
+// _order.json; a key's index in the array is its seq.
+func writeOrderFile(recordsPath string, order []string) error {
+	if order == nil {
+		order = []string{}
+	}
+	b, err := json.MarshalIndent(order, "", "  ")
 	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(commitPath+".json", recJson, 0666); err != nil {
-		return err
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return os.WriteFile(filepath.Join(recordsPath, "_order.json"), b, 0666)
+}
+
+// downloadBlobs fetches every blob in the repo that isn't already on disk,
+// returning the number of blobs the repo lists and the CIDs of those the
+// PDS no longer has. Those are skipped with a warning, or fail the repo
+// with -strict-blobs.
+func downloadBlobs(ctx context.Context, ident *identity.Identity, recordsPath string, config Config) (int, []string, error) {
+	topDir := blobDir(recordsPath, config)
+	logf("writing blobs to: %s\n", topDir)
+	os.MkdirAll(topDir, os.ModePerm)
+	names, err := openBlobNames(topDir, config)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	// then all the actual records
-	err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
-		_, rec, err := r.GetRecord(ctx, k)
-		if err != nil {
-			fmt.Printf("Warning: Failed to get record %s: %v\n", k, err)
-			return nil
+	if config.PDSDataDir != "" {
+		count, err := copyLocalBlobs(ident.DID.String(), topDir, names, config)
+		if !errors.Is(err, fs.ErrNotExist) {
+			if err == nil {
+				err = names.save()
+			}
+			return count, nil, err
 		}
+		logf("No local blobs for %s in %s; fetching them from the PDS\n", ident.DID, config.PDSDataDir)
+	}
 
-		recPath := filepath.Join(recordsPath, k)
-		fmt.Printf("%s.json\n", recPath)
-		os.MkdirAll(filepath.Dir(recPath), os.ModePerm)
-		recJson, err := json.MarshalIndent(rec, "", "  ")
-		if err != nil {
-			fmt.Printf("Warning: Failed to marshal record %s: %v\n", k, err)
-			return nil
-		}
-		if err := os.WriteFile(recPath+".json", recJson, 0666); err != nil {
-			return err
-		}
+	if relayFallback(ident, config) {
+		logf("Warning: relays don't serve blobs; skipping blobs of %s\n", ident.DID)
+		return 0, nil, nil
+	}
+
+	host := pdsHost(ident, config)
+	if host == "" {
+		return 0, nil, fmt.Errorf("no PDS endpoint for identity")
+	}
 
+	count := 0
+	var missing []string
+	err = forEachBlobPage(ctx, host, ident.DID.String(), func(cids []string) error {
+		for _, cidStr := range cids {
+			count++
+			blobPath := names.blobPath(topDir, cidStr, config.BlobShardDepth)
+			// a filter hit may be a false positive, or a blob deleted from the
+			// store since; either way it is downloaded again
+			if seenBlobs.has(cidStr) {
+				if fi, err := os.Stat(blobPath); err == nil {
+					logf("%s\tseen\n", blobPath)
+					dedup.addBlob(cidStr, fi.Size())
+					continue
+				}
+			}
+			if fi, err := os.Stat(blobPath); err == nil {
+				logf("%s\texists\n", blobPath)
+				seenBlobs.add(cidStr)
+				dedup.addBlob(cidStr, fi.Size())
+				continue
+			}
+			sizeLimited := config.MaxBlobBytes > 0 || config.MinBlobBytes > 0
+			if sizeLimited {
+				if size := headBlobSize(ctx, host, ident.DID.String(), cidStr); size >= 0 {
+					if ok, reason := blobSizeAllowed(size, config); !ok {
+						logf("%s\t%s (%d bytes)\n", blobPath, reason, size)
+						continue
+					}
+				}
+			}
+			var blobBytes []byte
+			err := session.withClient(ctx, host, func(c *xrpc.Client) error {
+				var err error
+				blobBytes, err = comatproto.SyncGetBlob(ctx, c, cidStr, ident.DID.String())
+				return err
+			})
+			if isBlobNotFound(err) && !config.StrictBlobs {
+				// listed a moment ago, but deleted or not yet replicated
+				logf("Warning: %s\tnot found on the PDS, skipping: %v\n", blobPath, err)
+				missing = append(missing, cidStr)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// the HEAD request may not have told us the size, so check again
+			if sizeLimited {
+				if ok, reason := blobSizeAllowed(int64(len(blobBytes)), config); !ok {
+					logf("%s\t%s (%d bytes)\n", blobPath, reason, len(blobBytes))
+					continue
+				}
+			}
+			if config.BlobShardDepth > 0 {
+				os.MkdirAll(filepath.Dir(blobPath), os.ModePerm)
+			}
+			if err := os.WriteFile(blobPath, blobBytes, 0666); err != nil {
+				return err
+			}
+			logf("%s\tdownloaded\n", blobPath)
+			seenBlobs.add(cidStr)
+			dedup.addBlob(cidStr, int64(len(blobBytes)))
+			events.emit(Event{Type: EventBlobDownloaded, DID: ident.DID.String(), Path: blobPath, CID: cidStr, Bytes: len(blobBytes)})
+		}
 		return nil
 	})
 	if err != nil {
-		return err
+		return count, missing, err
 	}
-	return nil
+	return count, missing, names.save()
 }
 
-func downloadBlobs(ctx context.Context, ident *identity.Identity, recordsPath string) error {
-	topDir := filepath.Join(recordsPath, "_blob")
-	fmt.Printf("writing blobs to: %s\n", topDir)
-	os.MkdirAll(topDir, os.ModePerm)
-
-	xrpcc := xrpc.Client{
-		Host: ident.PDSEndpoint(),
+// isBlobNotFound reports whether a getBlob error means the PDS doesn't have
+// the blob: a 404, or the BlobNotFound error some PDSes answer with.
+func isBlobNotFound(err error) bool {
+	var xerr *xrpc.Error
+	if !errors.As(err, &xerr) {
+		return false
 	}
-	if xrpcc.Host == "" {
-		return fmt.Errorf("no PDS endpoint for identity")
+	if xerr.StatusCode == http.StatusNotFound {
+		return true
 	}
+	var body *xrpc.XRPCError
+	return errors.As(err, &body) && body.ErrStr == "BlobNotFound"
+}
 
+// forEachBlobPage pages through com.atproto.sync.listBlobs for did on host,
+// calling fn with each page of CIDs.
+func forEachBlobPage(ctx context.Context, host, did string, fn func(cids []string) error) error {
 	cursor := ""
 	for {
-		resp, err := comatproto.SyncListBlobs(ctx, &xrpcc, cursor, ident.DID.String(), 500, "")
+		var resp *comatproto.SyncListBlobs_Output
+		err := session.withClient(ctx, host, func(c *xrpc.Client) error {
+			var err error
+			resp, err = comatproto.SyncListBlobs(ctx, c, cursor, did, 500, "")
+			return err
+		})
 		if err != nil {
 			return err
 		}
-		for _, cidStr := range resp.Cids {
-			blobPath := filepath.Join(topDir, cidStr)
-			if _, err := os.Stat(blobPath); err == nil {
-				fmt.Printf("%s\texists\n", blobPath)
-				continue
-			}
-			blobBytes, err := comatproto.SyncGetBlob(ctx, &xrpcc, cidStr, ident.DID.String())
-			if err != nil {
-				return err
-			}
-			if err := os.WriteFile(blobPath, blobBytes, 0666); err != nil {
-				return err
-			}
-			fmt.Printf("%s\tdownloaded\n", blobPath)
+		if err := fn(resp.Cids); err != nil {
+			return err
 		}
-		if resp.Cursor != nil && *resp.Cursor != "" {
-			cursor = *resp.Cursor
-		} else {
-			break
+		if resp.Cursor == nil || *resp.Cursor == "" {
+			return nil
 		}
+		cursor = *resp.Cursor
 	}
-	return nil
 }
 
-func carUnpack(carPath string) error {
-	ctx := context.Background()
-	fi, err := os.Open(carPath)
+// listBlobs writes the CIDs of every blob in the repo to _blobs.txt under
+// recordsPath, one per line, without downloading them. It returns the
+// number of blobs listed.
+func listBlobs(ctx context.Context, ident *identity.Identity, recordsPath string, config Config) (int, error) {
+	host := pdsHost(ident, config)
+	if host == "" {
+		return 0, fmt.Errorf("no PDS endpoint for identity")
+	}
+
+	var buf bytes.Buffer
+	count := 0
+	err := forEachBlobPage(ctx, host, ident.DID.String(), func(cids []string) error {
+		for _, cidStr := range cids {
+			buf.WriteString(cidStr)
+			buf.WriteByte('\n')
+		}
+		count += len(cids)
+		return nil
+	})
 	if err != nil {
-		return err
+		return count, err
 	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	listPath := filepath.Join(recordsPath, "_blobs.txt")
+	if err := os.WriteFile(listPath, buf.Bytes(), 0666); err != nil {
+		return count, err
+	}
+	logf("%s\t%d blobs\n", listPath, count)
+	return count, nil
+}
 
-	r, err := repo.ReadRepoFromCar(ctx, fi)
+// carUnpack writes the records of a local CAR file under outDir, which
+// defaults to a directory named after the repo's DID.
+func carUnpack(carPath, outDir string, config Config) error {
+	ctx := context.Background()
+	r, root, err := readCarRoot(ctx, carPath)
 	if err != nil {
 		return err
 	}
@@ -254,47 +1933,16 @@ func carUnpack(carPath string) error {
 		return err
 	}
 
-	topDir := did.String()
-	fmt.Printf("writing output to: %s\n", topDir)
-
-	// first the commit object as a meta file
-	commitPath := topDir + "/_commit"
-	os.MkdirAll(filepath.Dir(commitPath), os.ModePerm)
-	recJson, err := json.MarshalIndent(sc, "", "  ")
-	if err != nil {
-		return err
+	topDir := outDir
+	if topDir == "" {
+		topDir = did.String()
 	}
-	if err := os.WriteFile(commitPath+".json", recJson, 0666); err != nil {
-		return err
-	}
-
-	// then all the actual records
-	err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
-		_, rec, err := r.GetRecord(ctx, k)
-		if err != nil {
-			return err
-		}
-
-		recPath := topDir + "/" + k
-		fmt.Printf("%s.json\n", recPath)
-		os.MkdirAll(filepath.Dir(recPath), os.ModePerm)
-		if err != nil {
-			return err
-		}
-		recJson, err := json.MarshalIndent(rec, "", "  ")
-		if err != nil {
-			return err
-		}
-		if err := os.WriteFile(recPath+".json", recJson, 0666); err != nil {
-			return err
-		}
-
+	_, err = unpackRepo(ctx, r, root, topDir, repoState{}, config, nil)
+	if errors.Is(err, ErrEmptyRepo) {
+		logf("Info: %s has no records\n", did)
 		return nil
-	})
-	if err != nil {
-		return err
 	}
-	return nil
+	return err
 }
 
 func blobDownloadAll(raw string) error {
@@ -320,7 +1968,7 @@ func blobDownloadAll(raw string) error {
 	}
 
 	topDir := ident.DID.String() + "/_blob"
-	fmt.Printf("writing blobs to: %s\n", topDir)
+	logf("writing blobs to: %s\n", topDir)
 	os.MkdirAll(topDir, os.ModePerm)
 
 	cursor := ""
@@ -332,7 +1980,7 @@ func blobDownloadAll(raw string) error {
 		for _, cidStr := range resp.Cids {
 			blobPath := topDir + "/" + cidStr
 			if _, err := os.Stat(blobPath); err == nil {
-				fmt.Printf("%s\texists\n", blobPath)
+				logf("%s\texists\n", blobPath)
 				continue
 			}
 			blobBytes, err := comatproto.SyncGetBlob(ctx, &xrpcc, cidStr, ident.DID.String())
@@ -342,7 +1990,7 @@ func blobDownloadAll(raw string) error {
 			if err := os.WriteFile(blobPath, blobBytes, 0666); err != nil {
 				return err
 			}
-			fmt.Printf("%s\tdownloaded\n", blobPath)
+			logf("%s\tdownloaded\n", blobPath)
 		}
 		if resp.Cursor != nil && *resp.Cursor != "" {
 			cursor = *resp.Cursor
@@ -354,22 +2002,35 @@ func blobDownloadAll(raw string) error {
 }
 
 func readDIDsFromFile(filename string) ([]string, error) {
-    content, err := os.ReadFile(filename)
-    if err != nil {
-        return nil, fmt.Errorf("failed to read file: %w", err)
-    }
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
 
-    var dids []string
-    for _, line := range strings.Split(string(content), "\n") {
-        line = strings.TrimSpace(line)
-        if line != "" {
-            dids = append(dids, line)
-        }
-    }
+	var dids []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			dids = append(dids, line)
+		}
+	}
 
-    return dids, nil
+	return dids, nil
 }
 
-func getActivatedDIDs(ctx context.Context, filename string) ([]string, error) {
-    return readDIDsFromFile(filename)
-}
\ No newline at end of file
+func getActivatedDIDs(ctx context.Context, config Config) ([]string, error) {
+	dids, err := readDIDsFromFile(config.DIDsFile)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newDIDLineFilter(config.DIDFilter, config.DIDMethod)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		kept := filter.apply(dids)
+		logf("Filtered out %d of %d DIDs\n", len(dids)-len(kept), len(dids))
+		dids = kept
+	}
+	return dids, nil
+}
@@ -0,0 +1,107 @@
+package extractor
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"io"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+)
+
+// fixtureCar is a small repo: a profile and five posts.
+const fixtureCar = "testdata/repo.car"
+
+// benchConfig returns the unpack subcommand's defaults, with logs
+// discarded so they don't swamp the timings.
+func benchConfig(b *testing.B) Config {
+	b.Helper()
+	var config Config
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	addUnpackFlags(fs, &config)
+	if err := fs.Parse(nil); err != nil {
+		b.Fatal(err)
+	}
+	old := logw
+	logw = io.Discard
+	b.Cleanup(func() { logw = old })
+	return config
+}
+
+func loadFixture(b *testing.B) (*repo.Repo, cid.Cid) {
+	b.Helper()
+	r, root, err := readCarRoot(context.Background(), fixtureCar)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return r, root
+}
+
+func BenchmarkReadCar(b *testing.B) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := readCarRoot(ctx, fixtureCar); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnpackRepo(b *testing.B) {
+	config := benchConfig(b)
+	r, root := loadFixture(b)
+	dir := b.TempDir()
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := unpackRepo(ctx, r, root, filepath.Join(dir, strconv.Itoa(i)), repoState{}, config, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUnpackRepoSinks unpacks the fixture into each -format sink.
+func BenchmarkUnpackRepoSinks(b *testing.B) {
+	for _, format := range []string{FormatMsgpack, FormatCSV, FormatNDJSON, FormatParquet, FormatJSONLD, FormatBolt, FormatNone} {
+		b.Run(format, func(b *testing.B) {
+			config := benchConfig(b)
+			config.Format = format
+			r, root := loadFixture(b)
+			dir := b.TempDir()
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := unpackRepo(ctx, r, root, filepath.Join(dir, strconv.Itoa(i)), repoState{}, config, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWriteStream is "unpack -o -", with one worker and with four
+// keeping MST order.
+func BenchmarkWriteStream(b *testing.B) {
+	for _, workers := range []int{1, 4} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			config := benchConfig(b)
+			config.RecordWorkers = workers
+			config.PreserveOrder = true
+			r, _ := loadFixture(b)
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := bufio.NewWriterSize(io.Discard, writeBufferSize(config))
+				if err := writeStream(ctx, r, w, repoState{}, config); err != nil {
+					b.Fatal(err)
+				}
+				if err := w.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
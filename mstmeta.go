@@ -0,0 +1,96 @@
+package extractor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// MSTPosition is where a record sits in the repo's Merkle Search Tree: the
+// depth of the node holding its entry (0 is the root) and the CIDs of the
+// nodes leading there from the root.
+type MSTPosition struct {
+	Depth int      `json:"depth"`
+	Path  []string `json:"path"`
+}
+
+// mstPositions walks the repo's MST from the root and returns the position
+// of every record key. ForEach hides the tree shape, so this decodes the
+// node blocks itself.
+func mstPositions(ctx context.Context, r *repo.Repo) (map[string]MSTPosition, error) {
+	out := map[string]MSTPosition{}
+	if err := walkMSTNode(ctx, r, r.DataCid(), nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func walkMSTNode(ctx context.Context, r *repo.Repo, c cid.Cid, path []string, out map[string]MSTPosition) error {
+	blk, err := r.Blockstore().Get(ctx, c)
+	if err != nil {
+		return fmt.Errorf("reading MST node %s: %w", c, err)
+	}
+	var node map[string]any
+	if err := cbor.DecodeInto(blk.RawData(), &node); err != nil {
+		return fmt.Errorf("decoding MST node %s: %w", c, err)
+	}
+
+	path = append(path[:len(path):len(path)], c.String())
+	if left, ok := node["l"].(cid.Cid); ok {
+		if err := walkMSTNode(ctx, r, left, path, out); err != nil {
+			return err
+		}
+	}
+
+	entries, _ := node["e"].([]any)
+	var prev []byte
+	for _, raw := range entries {
+		e, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("malformed entry in MST node %s", c)
+		}
+		prefix, _ := toInt(e["p"])
+		suffix, _ := e["k"].([]byte)
+		if prefix > len(prev) {
+			return fmt.Errorf("bad key prefix in MST node %s", c)
+		}
+		key := append(append([]byte{}, prev[:prefix]...), suffix...)
+		prev = key
+		out[string(key)] = MSTPosition{Depth: len(path) - 1, Path: path}
+
+		if right, ok := e["t"].(cid.Cid); ok {
+			if err := walkMSTNode(ctx, r, right, path, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func toInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// writeMSTFile writes the record key to MST position mapping as _mst.json.
+func writeMSTFile(recordsPath string, positions map[string]MSTPosition) error {
+	b, err := json.MarshalIndent(positions, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return os.WriteFile(filepath.Join(recordsPath, "_mst.json"), b, 0666)
+}
@@ -0,0 +1,130 @@
+package extractor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// IdentityInfo is written as _identity.json in a repo's records directory so
+// the DID is kept even when the directory is named after the handle.
+// DeclaredHandle is the handle the DID document claims; HandleVerified is
+// whether that handle resolves back to the same DID.
+type IdentityInfo struct {
+	DID            string `json:"did"`
+	Handle         string `json:"handle"`
+	DeclaredHandle string `json:"declared_handle,omitempty"`
+	HandleVerified bool   `json:"handle_verified"`
+	PDS            string `json:"pds,omitempty"`
+}
+
+func writeIdentityFile(recordsPath string, ident *identity.Identity) error {
+	declared, verified := verifyHandle(ident)
+	info := IdentityInfo{
+		DID:            ident.DID.String(),
+		Handle:         ident.Handle.String(),
+		DeclaredHandle: declared,
+		HandleVerified: verified,
+		PDS:            ident.PDSEndpoint(),
+	}
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return os.WriteFile(filepath.Join(recordsPath, "_identity.json"), b, 0666)
+}
+
+// verifyHandle returns the handle declared in the DID document and whether
+// it checked out both ways. The identity directory has already resolved the
+// declared handle and replaced it with handle.invalid if it didn't point
+// back at this DID, so this only compares the two.
+func verifyHandle(ident *identity.Identity) (string, bool) {
+	declared, err := ident.DeclaredHandle()
+	if err != nil {
+		return "", false
+	}
+	return declared.String(), ident.Handle != syntax.HandleInvalid && ident.Handle == declared
+}
+
+func readIdentityFile(recordsPath string) (IdentityInfo, error) {
+	var info IdentityInfo
+	b, err := os.ReadFile(filepath.Join(recordsPath, "_identity.json"))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+// handleNamer picks handle-based directory names, making sure two DIDs
+// never share one, whether in this run or from an earlier one.
+type handleNamer struct {
+	mu    sync.Mutex
+	owner map[string]string // directory name -> DID
+}
+
+var handleNames = &handleNamer{owner: make(map[string]string)}
+
+// dirName returns the records directory name for ident under root. It is
+// the sanitized handle, or the DID when the handle doesn't resolve. If
+// another DID already owns the handle's name, a short DID suffix is added.
+func (hn *handleNamer) dirName(root string, ident *identity.Identity) string {
+	did := ident.DID.String()
+	if ident.Handle == syntax.HandleInvalid || ident.Handle == "" {
+		return did
+	}
+
+	hn.mu.Lock()
+	defer hn.mu.Unlock()
+
+	name := sanitizeHandle(ident.Handle.String())
+	if hn.taken(root, name, did) {
+		name = name + "-" + shortDID(did)
+	}
+	hn.owner[name] = did
+	return name
+}
+
+func (hn *handleNamer) taken(root, name, did string) bool {
+	if owner, ok := hn.owner[name]; ok {
+		return owner != did
+	}
+	info, err := readIdentityFile(filepath.Join(root, name))
+	if err != nil {
+		// nothing there yet (or not ours to judge); if the directory
+		// exists without an identity file, don't risk mixing repos
+		_, statErr := os.Stat(filepath.Join(root, name))
+		return statErr == nil
+	}
+	return info.DID != did
+}
+
+// sanitizeHandle lowercases a handle and replaces anything outside
+// [a-z0-9.-] so it is safe as a path segment everywhere.
+func sanitizeHandle(h string) string {
+	h = strings.ToLower(h)
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, h)
+}
+
+// shortDID returns the last eight characters of a DID's method-specific
+// identifier.
+func shortDID(did string) string {
+	id := did[strings.LastIndex(did, ":")+1:]
+	if len(id) > 8 {
+		id = id[len(id)-8:]
+	}
+	return id
+}
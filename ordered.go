@@ -0,0 +1,84 @@
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+
+	"github.com/bluesky-social/indigo/repo"
+)
+
+// streamRecords renders a repo as NDJSON in the "unpack -o -" format, for
+// -ordered-output. It returns the number of record lines and the rendered
+// bytes.
+func streamRecords(ctx context.Context, r *repo.Repo, st repoState, config Config) (int, []byte, error) {
+	var buf bytes.Buffer
+	if err := writeStream(ctx, r, &buf, st, config); err != nil {
+		return 0, nil, err
+	}
+	lines := bytes.Count(buf.Bytes(), []byte{'\n'})
+	if st.writesCommit(config) {
+		lines--
+	}
+	return lines, buf.Bytes(), nil
+}
+
+// orderedOutput writes every repo's NDJSON to one file in the order of the
+// DIDs list, however the workers finish. A nil *orderedOutput does nothing.
+type orderedOutput struct {
+	f  *os.File
+	bw *bufio.Writer
+	// repos that finished early wait in pending until every repo before
+	// them has been written; failed repos are present with no bytes
+	pending map[int][]byte
+	next    int
+	err     error
+}
+
+func openOrderedOutput(path string, config Config) (*orderedOutput, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &orderedOutput{f: f, bw: bufio.NewWriterSize(f, writeBufferSize(config)), pending: map[int][]byte{}}, nil
+}
+
+// add takes a finished repo's output and writes out every repo that is now
+// next in line. After a write error nothing more is written; close
+// returns the error.
+func (o *orderedOutput) add(res RepoResult) {
+	if o == nil {
+		return
+	}
+	o.pending[res.index] = res.stream
+	for {
+		b, ok := o.pending[o.next]
+		if !ok {
+			return
+		}
+		delete(o.pending, o.next)
+		o.next++
+		if o.err == nil {
+			_, o.err = o.bw.Write(b)
+		}
+	}
+}
+
+// close flushes and closes the file. Repos still waiting for an earlier one,
+// as after an interrupted run, are not written.
+func (o *orderedOutput) close() error {
+	if o == nil {
+		return nil
+	}
+	if o.err == nil {
+		o.err = o.bw.Flush()
+	}
+	if err := o.f.Close(); o.err == nil {
+		o.err = err
+	}
+	return o.err
+}
@@ -0,0 +1,44 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOrderedOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.ndjson")
+	o, err := openOrderedOutput(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// finished out of order; 2 failed and has no output; 5 is still
+	// waiting for 4 when the run stops
+	o.add(RepoResult{index: 1, stream: []byte("b\n")})
+	o.add(RepoResult{index: 3, stream: []byte("d\n")})
+	o.add(RepoResult{index: 0, stream: []byte("a\n")})
+	o.add(RepoResult{index: 2})
+	o.add(RepoResult{index: 5, stream: []byte("f\n")})
+	if err := o.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "a\nb\nd\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestOrderedOutputNil(t *testing.T) {
+	o, err := openOrderedOutput("", Config{})
+	if err != nil || o != nil {
+		t.Fatalf("openOrderedOutput without a path = %v, %v; want nil, nil", o, err)
+	}
+	o.add(RepoResult{index: 0, stream: []byte("a\n")})
+	if err := o.close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,104 @@
+package extractor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRow is the envelope every record is written in with -format
+// parquet. Records of different collections have different shapes, so the
+// record itself is kept as a JSON string for the query engine to unpack.
+type parquetRow struct {
+	DID        string  `parquet:"did,dict"`
+	Collection string  `parquet:"collection,dict"`
+	Rkey       string  `parquet:"rkey"`
+	CID        string  `parquet:"cid"`
+	CreatedAt  *string `parquet:"createdAt,optional"`
+	JSON       string  `parquet:"json"`
+}
+
+// parquetBatchRows is how many rows a parquet file collects before handing
+// them to the writer.
+const parquetBatchRows = 1024
+
+// parquetSink writes one zstd-compressed <collection>.parquet per
+// collection.
+type parquetSink struct {
+	dir       string
+	canonical bool
+	files     map[string]*parquetFile
+}
+
+type parquetFile struct {
+	f    *os.File
+	w    *parquet.GenericWriter[parquetRow]
+	rows []parquetRow
+}
+
+func (ps *parquetSink) write(rec outRecord) error {
+	pf, ok := ps.files[rec.Collection]
+	if !ok {
+		os.MkdirAll(ps.dir, os.ModePerm)
+		name, _ := safePathSegment(rec.Collection)
+		f, err := os.Create(filepath.Join(ps.dir, name+".parquet"))
+		if err != nil {
+			return err
+		}
+		pf = &parquetFile{f: f, w: parquet.NewGenericWriter[parquetRow](f, parquet.Compression(&parquet.Zstd))}
+		ps.files[rec.Collection] = pf
+	}
+
+	var b []byte
+	var err error
+	if ps.canonical {
+		b, err = canonicalJSON(rec.Value)
+	} else {
+		b, err = json.Marshal(rec.Value)
+	}
+	if err != nil {
+		return err
+	}
+	did, _, _ := strings.Cut(strings.TrimPrefix(rec.URI, "at://"), "/")
+	row := parquetRow{DID: did, Collection: rec.Collection, Rkey: rec.Rkey, CID: rec.CID, JSON: string(b)}
+	generic, err := toGeneric(rec.Value)
+	if err != nil {
+		return err
+	}
+	if m, ok := generic.(map[string]any); ok {
+		if s, ok := m["createdAt"].(string); ok {
+			row.CreatedAt = &s
+		}
+	}
+
+	pf.rows = append(pf.rows, row)
+	if len(pf.rows) >= parquetBatchRows {
+		return pf.flush()
+	}
+	return nil
+}
+
+func (pf *parquetFile) flush() error {
+	_, err := pf.w.Write(pf.rows)
+	pf.rows = pf.rows[:0]
+	return err
+}
+
+func (ps *parquetSink) close() error {
+	var first error
+	for _, pf := range ps.files {
+		if err := pf.flush(); err != nil && first == nil {
+			first = err
+		}
+		if err := pf.w.Close(); err != nil && first == nil {
+			first = err
+		}
+		if err := pf.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,215 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/mst"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// scopedFetch reports whether the current repo is fetched record by record
+// instead of as a CAR: -record-level is on and its DIDs file entry selects
+// collections or records.
+func scopedFetch(st repoState, config Config) bool {
+	return config.RecordLevel && len(st.scope) > 0
+}
+
+// rawRecord is a record as com.atproto.repo.listRecords and getRecord
+// return it, with the value left undecoded.
+type rawRecord struct {
+	URI   string          `json:"uri"`
+	CID   string          `json:"cid"`
+	Value json.RawMessage `json:"value"`
+}
+
+// fetchScopedRepo fetches the records in scope with
+// com.atproto.repo.listRecords (whole collections) and getRecord (single
+// records), and assembles them into an in-memory repo so that they can be
+// unpacked like a downloaded CAR. The records are re-encoded from JSON and
+// rehashed, but there is no signed commit or MST from the PDS to verify
+// them against: the tree is rebuilt locally and the commit is unsigned.
+func fetchScopedRepo(ctx context.Context, ident *identity.Identity, scope []string, config Config) (*repo.Repo, error) {
+	host := pdsHost(ident, config)
+	if host == "" {
+		return nil, fmt.Errorf("no PDS endpoint for identity")
+	}
+	did := ident.DID.String()
+
+	var latest *comatproto.SyncGetLatestCommit_Output
+	var found []rawRecord
+	err := session.withClient(ctx, host, func(c *xrpc.Client) error {
+		var err error
+		latest, err = comatproto.SyncGetLatestCommit(ctx, c, did)
+		if err != nil {
+			return err
+		}
+		found, err = listScopedRecords(ctx, c, did, scope)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := util.CborStore(bs)
+	tree := mst.NewEmptyMST(cst)
+	seen := map[string]bool{}
+	mismatched := 0
+	for _, rec := range found {
+		k, ok := strings.CutPrefix(rec.URI, "at://"+did+"/")
+		if !ok || seen[k] {
+			continue
+		}
+		seen[k] = true
+		c, err := cid.Decode(rec.CID)
+		if err != nil {
+			return nil, fmt.Errorf("bad CID for %s: %w", rec.URI, err)
+		}
+		raw, err := recordJSONToCBOR(rec.Value)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", rec.URI, err)
+		}
+		if sum, err := c.Prefix().Sum(raw); err != nil || !sum.Equals(c) {
+			mismatched++
+		}
+		// keep the CID the PDS reported, so -verify-output flags records
+		// whose re-encoding doesn't reproduce it
+		blk, err := blocks.NewBlockWithCid(raw, c)
+		if err != nil {
+			return nil, err
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			return nil, err
+		}
+		if tree, err = tree.Add(ctx, k, c, -1); err != nil {
+			return nil, err
+		}
+	}
+	if mismatched > 0 {
+		logf("Warning: %d records of %s don't re-encode to the CID the PDS listed\n", mismatched, did)
+	}
+
+	root, err := tree.GetPointer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sc := repo.SignedCommit{Did: did, Version: repo.ATP_REPO_VERSION, Data: root, Rev: latest.Rev}
+	commit, err := cst.Put(ctx, &sc)
+	if err != nil {
+		return nil, err
+	}
+	return repo.OpenRepo(ctx, bs, commit)
+}
+
+// listScopedRecords fetches every record selected by scope, whose entries
+// are collections or collection/rkey paths.
+func listScopedRecords(ctx context.Context, c *xrpc.Client, did string, scope []string) ([]rawRecord, error) {
+	var found []rawRecord
+	for _, s := range scope {
+		collection, rkey, single := strings.Cut(s, "/")
+		if single {
+			var rec rawRecord
+			params := map[string]any{"repo": did, "collection": collection, "rkey": rkey}
+			err := c.Do(ctx, xrpc.Query, "", "com.atproto.repo.getRecord", params, nil, &rec)
+			var body *xrpc.XRPCError
+			if errors.As(err, &body) && body.ErrStr == "RecordNotFound" {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get %s: %w", s, err)
+			}
+			found = append(found, rec)
+			continue
+		}
+
+		cursor := ""
+		for {
+			var out struct {
+				Cursor  *string     `json:"cursor"`
+				Records []rawRecord `json:"records"`
+			}
+			params := map[string]any{"repo": did, "collection": collection, "limit": 100, "cursor": cursor}
+			if err := c.Do(ctx, xrpc.Query, "", "com.atproto.repo.listRecords", params, nil, &out); err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", collection, err)
+			}
+			found = append(found, out.Records...)
+			if out.Cursor == nil || *out.Cursor == "" || len(out.Records) == 0 {
+				break
+			}
+			cursor = *out.Cursor
+		}
+	}
+	return found, nil
+}
+
+// recordJSONToCBOR encodes a record's atproto JSON as DAG-CBOR. Numbers
+// are decoded as json.Number and must be integers, so values beyond
+// float64's 53 bits of precision come through exactly; {"$link"} and
+// {"$bytes"} objects become CIDs and byte strings.
+func recordJSONToCBOR(raw json.RawMessage) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	v, err := fromJSONData(generic)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.DumpObject(v)
+}
+
+// fromJSONData converts generic JSON values of the atproto data model to
+// the values go-ipld-cbor encodes.
+func fromJSONData(v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("number %s is not an integer", v)
+		}
+		return n, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			if out[i], err = fromJSONData(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]any:
+		if s, ok := v["$link"].(string); ok && len(v) == 1 {
+			return cid.Decode(s)
+		}
+		if s, ok := v["$bytes"].(string); ok && len(v) == 1 {
+			return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+		}
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			var err error
+			if out[k], err = fromJSONData(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,49 @@
+package extractor
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRecordJSONToCBOR(t *testing.T) {
+	in := `{
+		"$type": "app.bsky.feed.post",
+		"text": "hi",
+		"big": 9007199254740993,
+		"langs": ["en", "fr"],
+		"blob": {"$type": "blob", "ref": {"$link": "bafyreigdbijbu5fmzwkyzdy4kzkgkd2xinxofswctczsa25ap3a6t7qcsu"}, "mimeType": "image/png", "size": 10},
+		"raw": {"$bytes": "aGVsbG8"}
+	}`
+	b, err := recordJSONToCBOR(json.RawMessage(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := decodeRecord(b, false)
+	if err != nil {
+		t.Fatalf("decoding the CBOR: %v", err)
+	}
+	got, err := canonicalJSON(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := canonicalJSON(json.RawMessage(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("round trip gave\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRecordJSONToCBORErrors(t *testing.T) {
+	for _, in := range []string{
+		`{"n": 1.5}`,
+		`{"ref": {"$link": "not-a-cid"}}`,
+		`{"raw": {"$bytes": "!!"}}`,
+		`{"text": `,
+	} {
+		if _, err := recordJSONToCBOR(json.RawMessage(in)); err == nil {
+			t.Errorf("recordJSONToCBOR(%s) succeeded, want an error", in)
+		}
+	}
+}
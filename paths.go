@@ -0,0 +1,135 @@
+package extractor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// maxSegmentLen keeps each path segment well under the 255-byte limit of
+// common filesystems, leaving room for the ".json" suffix.
+const maxSegmentLen = 200
+
+// windowsReserved are device names Windows refuses as file names, with or
+// without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// safePathSegment returns a form of seg that is a valid file name on Linux,
+// macOS and Windows, and whether it had to change. Characters outside the
+// portable set are %-escaped (':' becomes "%3A"), as are the first letter of
+// Windows device names and a trailing dot, and over-long segments are cut
+// and suffixed with a hash of the original. '%' never appears in an NSID or
+// record key, so sanitized names can't collide with real ones.
+func safePathSegment(seg string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		portable := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '.' || c == '-' || c == '_' || c == '~'
+		if !portable || (c == '.' && i == len(seg)-1) {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	out := b.String()
+
+	base, _, _ := strings.Cut(out, ".")
+	switch {
+	case out == "":
+		out = "%"
+	case windowsReserved[strings.ToUpper(base)], out == ".", out == "..":
+		out = fmt.Sprintf("%%%02X", out[0]) + out[1:]
+	}
+	if len(out) > maxSegmentLen {
+		sum := sha256.Sum256([]byte(seg))
+		out = out[:maxSegmentLen-17] + "%" + hex.EncodeToString(sum[:8])
+	}
+	return out, out != seg
+}
+
+// recordFilePath returns where the record at key k ("<collection>/<rkey>")
+// is written under recordsPath, without the ".json" extension, and whether
+// either part had to be sanitized.
+func recordFilePath(recordsPath, k string) (string, bool) {
+	collection, rkey, _ := strings.Cut(k, "/")
+	c, cchanged := safePathSegment(collection)
+	r, rchanged := safePathSegment(rkey)
+	return filepath.Join(recordsPath, c, r), cchanged || rchanged
+}
+
+// writePathsFile writes _paths.json, mapping each sanitized record file
+// (relative to recordsPath) back to its original record key.
+func writePathsFile(recordsPath string, paths map[string]string) error {
+	b, err := json.MarshalIndent(paths, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return os.WriteFile(filepath.Join(recordsPath, "_paths.json"), b, 0666)
+}
+
+// Default names of the commit file and of record files' suffix, changed
+// with -commit-file-name and -record-suffix.
+const (
+	defaultCommitFileName = "_commit.json"
+	defaultRecordSuffix   = ".json"
+)
+
+// reservedNames are the files and directories other options write next to
+// the commit file.
+var reservedNames = []string{
+	"_account", "_blob", "_blob_refs.ndjson", "_cids.json", "_highwater.json",
+	"_identity.json", "_labels.json", "_lock", "_mst.json", "_order.json",
+	"_paths.json", "_rev_regressions.ndjson", "_seq.json", "_stats.json", "_uris.txt",
+	"SUMMARY.md", "provenance.json",
+}
+
+func commitFileName(config Config) string {
+	if config.CommitFileName == "" {
+		return defaultCommitFileName
+	}
+	return config.CommitFileName
+}
+
+func recordSuffix(config Config) string {
+	if config.RecordSuffix == "" {
+		return defaultRecordSuffix
+	}
+	return config.RecordSuffix
+}
+
+// checkOutputNames rejects a commit file name or record suffix that isn't a
+// plain file name or that clashes with another sidecar file. A commit file
+// name that is a valid NSID could also be a collection's directory; that
+// only gets a warning, as no repo might have such a collection.
+func checkOutputNames(config Config) error {
+	name := commitFileName(config)
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("-commit-file-name %q must be a plain file name", name)
+	}
+	for _, r := range reservedNames {
+		if strings.EqualFold(name, r) {
+			return fmt.Errorf("-commit-file-name %q is already used for another file", name)
+		}
+	}
+	if strings.ContainsAny(recordSuffix(config), `/\`) {
+		return fmt.Errorf("-record-suffix %q can't contain a path separator", config.RecordSuffix)
+	}
+	if _, err := syntax.ParseNSID(name); err == nil {
+		logf("Warning: -commit-file-name %q is a valid collection name and would clash with the directory of a collection of that name\n", name)
+	}
+	return nil
+}
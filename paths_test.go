@@ -0,0 +1,55 @@
+package extractor
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSafePathSegment(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		changed bool
+	}{
+		{"app.bsky.feed.post", "app.bsky.feed.post", false},
+		{"3kxyzabcdef22", "3kxyzabcdef22", false},
+		{"self", "self", false},
+		{"a:b", "a%3Ab", true},
+		{"trailing.", "trailing%2E", true},
+		{"CON", "%43ON", true},
+		{"nul.txt", "%6Eul.txt", true},
+		{".", "%2E", true},
+		{"..", ".%2E", true},
+		{"", "%", true},
+	}
+	for _, tt := range tests {
+		got, changed := safePathSegment(tt.in)
+		if got != tt.want || changed != tt.changed {
+			t.Errorf("safePathSegment(%q) = %q, %v; want %q, %v", tt.in, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestSafePathSegmentLong(t *testing.T) {
+	long := strings.Repeat("a", maxSegmentLen+10)
+	got, changed := safePathSegment(long)
+	if !changed || len(got) != maxSegmentLen {
+		t.Fatalf("safePathSegment of %d bytes = %d bytes, changed %v; want %d bytes, changed", len(long), len(got), changed, maxSegmentLen)
+	}
+	other, _ := safePathSegment(long + "b")
+	if other == got {
+		t.Errorf("different long segments cut to the same name %q", got)
+	}
+}
+
+func TestRecordFilePath(t *testing.T) {
+	got, sanitized := recordFilePath("out", "app.bsky.feed.post/a:b")
+	if want := filepath.Join("out", "app.bsky.feed.post", "a%3Ab"); got != want || !sanitized {
+		t.Errorf("recordFilePath = %q, %v; want %q, true", got, sanitized, want)
+	}
+	got, sanitized = recordFilePath("out", "app.bsky.actor.profile/self")
+	if want := filepath.Join("out", "app.bsky.actor.profile", "self"); got != want || sanitized {
+		t.Errorf("recordFilePath = %q, %v; want %q, false", got, sanitized, want)
+	}
+}
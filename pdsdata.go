@@ -0,0 +1,175 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	_ "modernc.org/sqlite"
+)
+
+// A reference PDS keeps each account in its own SQLite actor store under
+// its data directory (PDS_DATA_DIRECTORY), and blobs on disk when it uses
+// the disk blobstore:
+//
+//	<data>/actors/<first 2 hex of sha256(did)>/<did>/store.sqlite
+//	<data>/blocks/<did>/<blob cid>
+//
+// The repo itself is the repo_root row (the commit CID) and every
+// repo_block row (cid, content) of the actor store.
+
+// actorStorePath returns the SQLite actor store of did under dataDir.
+func actorStorePath(dataDir, did string) string {
+	sum := sha256.Sum256([]byte(did))
+	return filepath.Join(dataDir, "actors", hex.EncodeToString(sum[:])[:2], did, "store.sqlite")
+}
+
+// localBlobPath returns where the disk blobstore keeps a blob.
+func localBlobPath(dataDir, did, cidStr string) string {
+	return filepath.Join(dataDir, "blocks", did, cidStr)
+}
+
+// localRepoCar builds a CAR of did's repo from its actor store under
+// dataDir, as com.atproto.sync.getRepo would return it.
+func localRepoCar(ctx context.Context, dataDir, did string) ([]byte, error) {
+	path := actorStorePath(dataDir, did)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no actor store for %s: %w", did, err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var rootStr string
+	err = db.QueryRowContext(ctx, "SELECT cid FROM repo_root WHERE did = ?", did).Scan(&rootStr)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("actor store has no repo root for %s", did)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading repo root: %w", err)
+	}
+	root, err := cid.Decode(rootStr)
+	if err != nil {
+		return nil, fmt.Errorf("bad repo root %q: %w", rootStr, err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCarHeader(&buf, root); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT cid, content FROM repo_block")
+	if err != nil {
+		return nil, fmt.Errorf("reading repo blocks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cidStr string
+		var content []byte
+		if err := rows.Scan(&cidStr, &content); err != nil {
+			return nil, err
+		}
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad block cid %q: %w", cidStr, err)
+		}
+		writeCarSection(&buf, c.Bytes(), content)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCarHeader writes a CARv1 header with a single root.
+func writeCarHeader(buf *bytes.Buffer, root cid.Cid) error {
+	hdr, err := cbor.DumpObject(map[string]any{
+		"roots":   []cid.Cid{root},
+		"version": 1,
+	})
+	if err != nil {
+		return err
+	}
+	writeCarSection(buf, hdr)
+	return nil
+}
+
+// writeCarSection writes the parts as one varint-length-prefixed CAR
+// section.
+func writeCarSection(buf *bytes.Buffer, parts ...[]byte) {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	buf.Write(binary.AppendUvarint(nil, uint64(n)))
+	for _, p := range parts {
+		buf.Write(p)
+	}
+}
+
+// copyLocalBlobs copies did's blobs from the disk blobstore under dataDir
+// into topDir, named by names, applying the same size limits as downloads.
+// It returns an fs.ErrNotExist error if the account has no blob directory
+// there, as when the PDS stores blobs in S3.
+func copyLocalBlobs(did, topDir string, names *blobNames, config Config) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(config.PDSDataDir, "blocks", did))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		cidStr := e.Name()
+		if _, err := cid.Decode(cidStr); err != nil {
+			continue
+		}
+		count++
+		blobPath := names.blobPath(topDir, cidStr, config.BlobShardDepth)
+		// a filter hit may be a false positive, or a blob deleted from the
+		// store since; either way it is downloaded again
+		if seenBlobs.has(cidStr) {
+			if fi, err := os.Stat(blobPath); err == nil {
+				logf("%s\tseen\n", blobPath)
+				dedup.addBlob(cidStr, fi.Size())
+				continue
+			}
+		}
+		if fi, err := os.Stat(blobPath); err == nil {
+			logf("%s\texists\n", blobPath)
+			seenBlobs.add(cidStr)
+			dedup.addBlob(cidStr, fi.Size())
+			continue
+		}
+		blobBytes, err := os.ReadFile(localBlobPath(config.PDSDataDir, did, cidStr))
+		if err != nil {
+			return count, err
+		}
+		if ok, reason := blobSizeAllowed(int64(len(blobBytes)), config); !ok {
+			logf("%s\t%s (%d bytes)\n", blobPath, reason, len(blobBytes))
+			continue
+		}
+		if config.BlobShardDepth > 0 {
+			os.MkdirAll(filepath.Dir(blobPath), os.ModePerm)
+		}
+		if err := os.WriteFile(blobPath, blobBytes, 0666); err != nil {
+			return count, err
+		}
+		logf("%s\tcopied\n", blobPath)
+		seenBlobs.add(cidStr)
+		dedup.addBlob(cidStr, int64(len(blobBytes)))
+		events.emit(Event{Type: EventBlobDownloaded, DID: did, Path: blobPath, CID: cidStr, Bytes: len(blobBytes)})
+	}
+	return count, nil
+}
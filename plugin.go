@@ -0,0 +1,64 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"plugin"
+
+	"github.com/ipfs/go-cid"
+)
+
+// RepoContext describes the repo a RecordHandler is called for.
+type RepoContext struct {
+	DID         string
+	Rev         string
+	RecordsPath string
+}
+
+// RecordHandler receives every decoded record after filtering and
+// redaction, before it is written. It may change value in place; returning
+// ErrDropRecord keeps the record out of the output, which is how a handler
+// that is the sink replaces file writing. Any other error fails the repo.
+type RecordHandler func(ctx context.Context, rc RepoContext, key string, c cid.Cid, value any) error
+
+// ErrDropRecord is returned by a RecordHandler for records it has consumed
+// or rejected.
+var ErrDropRecord = errors.New("record dropped by handler")
+
+// pluginSymbol is what a -record-plugin must export: a func with the
+// signature of RecordHandler, from a plugin that imports this package, or
+// one that needs nothing from it and reports a drop by returning keep
+// false rather than ErrDropRecord:
+//
+//	func HandleRecord(ctx context.Context, did, rev, key string, c cid.Cid, value any) (keep bool, err error)
+const pluginSymbol = "HandleRecord"
+
+// loadRecordPlugin opens a Go plugin built with -buildmode=plugin against
+// the same dependency versions as this binary and adapts its HandleRecord.
+func loadRecordPlugin(path string) (RecordHandler, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	switch handle := sym.(type) {
+	case func(context.Context, RepoContext, string, cid.Cid, any) error:
+		return handle, nil
+	case func(context.Context, string, string, string, cid.Cid, any) (bool, error):
+		return func(ctx context.Context, rc RepoContext, key string, c cid.Cid, value any) error {
+			keep, err := handle(ctx, rc.DID, rc.Rev, key, c, value)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				return ErrDropRecord
+			}
+			return nil
+		}, nil
+	}
+	return nil, fmt.Errorf("%s in %s has type %T, not a RecordHandler or func(context.Context, string, string, string, cid.Cid, any) (bool, error)", pluginSymbol, path, sym)
+}
@@ -0,0 +1,48 @@
+package extractor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestRecordHandler(t *testing.T) {
+	const did = "did:plc:testtesttesttesttesttest"
+	dir := t.TempDir()
+	var seen []string
+	config := Config{
+		CarsDir:    filepath.Join(dir, "cars"),
+		RecordsDir: filepath.Join(dir, "records"),
+		LocalCars:  map[string]string{did: filepath.Join("testdata", "repo.car")},
+		// keep the profile, take over the posts
+		RecordHandler: func(ctx context.Context, rc RepoContext, key string, c cid.Cid, value any) error {
+			if rc.DID != did || rc.RecordsPath != filepath.Join(dir, "records", did) {
+				t.Errorf("RepoContext = %+v", rc)
+			}
+			seen = append(seen, key)
+			if strings.HasPrefix(key, "app.bsky.feed.post/") {
+				return ErrDropRecord
+			}
+			return nil
+		},
+	}
+
+	for res := range ExtractAll(context.Background(), config, []string{did}) {
+		if res.Status != StatusOK {
+			t.Fatalf("ExtractAll = %+v", res)
+		}
+	}
+	if len(seen) != 6 {
+		t.Errorf("handler saw %d records, want 6", len(seen))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "records", did, "app.bsky.feed.post")); !os.IsNotExist(err) {
+		t.Errorf("dropped posts were written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "records", did, "app.bsky.actor.profile", "self.json")); err != nil {
+		t.Errorf("kept profile wasn't written: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package extractor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// runPostCommand runs command through sh after a repo completes, with the
+// repo's details in REPO_DID, REPO_HANDLE, RECORDS_DIR and CAR_PATH so the
+// command can refer to them as "$REPO_DID" and so on. It returns the
+// combined stdout and stderr.
+func runPostCommand(ctx context.Context, command string, res RepoResult) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"REPO_DID="+res.DID,
+		"REPO_HANDLE="+res.Handle,
+		"RECORDS_DIR="+res.RecordsPath,
+		"CAR_PATH="+res.CarPath,
+	)
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,109 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// preflightTimeout bounds each host's describeServer check.
+const preflightTimeout = 10 * time.Second
+
+// HostStatus is the preflight outcome for one PDS host.
+type HostStatus struct {
+	Host  string
+	Repos int
+	Up    bool
+	Error string
+}
+
+// runPreflight resolves every DID to its PDS, checks each distinct host with
+// com.atproto.server.describeServer and prints which are up. It returns the
+// number of hosts that are down.
+func runPreflight(ctx context.Context, config Config, dids []string) int {
+	hosts := map[string]int{}
+	unresolved := 0
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	dir := identity.DefaultDirectory()
+	for i := 0; i < max(config.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for did := range jobs {
+				host, err := resolvePDS(ctx, dir, did, config)
+				mu.Lock()
+				if err != nil {
+					logf("Warning: could not resolve %s: %v\n", did, err)
+					unresolved++
+				} else {
+					hosts[host]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, did := range dids {
+		jobs <- did
+	}
+	close(jobs)
+	wg.Wait()
+
+	statuses := make([]HostStatus, 0, len(hosts))
+	for host, n := range hosts {
+		statuses = append(statuses, HostStatus{Host: host, Repos: n})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+
+	down := 0
+	for i := range statuses {
+		st := &statuses[i]
+		if err := checkHost(ctx, st.Host); err != nil {
+			st.Error = err.Error()
+			down++
+			logf("DOWN %s (%d repos): %v\n", st.Host, st.Repos, err)
+			continue
+		}
+		st.Up = true
+		logf("UP   %s (%d repos)\n", st.Host, st.Repos)
+	}
+	logf("\nPreflight: %d hosts, %d up, %d down, %d DIDs unresolved\n", len(statuses), len(statuses)-down, down, unresolved)
+	return down
+}
+
+func resolvePDS(ctx context.Context, dir identity.Directory, did string, config Config) (string, error) {
+	atid, err := syntax.ParseAtIdentifier(did)
+	if err != nil {
+		return "", err
+	}
+	if atid.IsDID() {
+		if err := checkDIDMethod(atid.String()); err != nil {
+			return "", err
+		}
+	}
+	ident, err := dir.Lookup(ctx, *atid)
+	if err != nil {
+		return "", err
+	}
+	host := pdsHost(ident, config)
+	if host == "" {
+		return "", fmt.Errorf("no PDS endpoint in DID document")
+	}
+	return host, nil
+}
+
+func checkHost(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	_, err := comatproto.ServerDescribeServer(ctx, &xrpc.Client{Host: host})
+	return err
+}
@@ -0,0 +1,87 @@
+package extractor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// progressInterval is how often -progress-file is rewritten.
+const progressInterval = 5 * time.Second
+
+// Progress is the content of -progress-file, a snapshot of the run for
+// tools watching it from outside.
+type Progress struct {
+	Started  time.Time `json:"started"`
+	Updated  time.Time `json:"updated"`
+	Finished bool      `json:"finished"`
+	Total    int       `json:"total"`
+	Done     int       `json:"done"`
+	Failed   int       `json:"failed"`
+	Bytes    int64     `json:"bytes"`
+	// ETA is when the run should finish at the rate so far, once any repo
+	// is done
+	ETA          *time.Time     `json:"eta,omitempty"`
+	InFlight     []ProgressRepo `json:"in_flight"`
+	RecentErrors []string       `json:"recent_errors,omitempty"`
+}
+
+// ProgressRepo is a repo being worked on.
+type ProgressRepo struct {
+	DID     string    `json:"did"`
+	Phase   string    `json:"phase"`
+	Records int       `json:"records"`
+	Blobs   int       `json:"blobs"`
+	Since   time.Time `json:"since"`
+}
+
+// progress returns the dashboard's state as a Progress.
+func (d *dashboard) progress(finished bool) Progress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	p := Progress{
+		Started:      d.started,
+		Updated:      now,
+		Finished:     finished,
+		Total:        d.total,
+		Done:         d.done,
+		Failed:       d.failed,
+		Bytes:        d.bytes,
+		InFlight:     []ProgressRepo{},
+		RecentErrors: append([]string(nil), d.errors...),
+	}
+	if !finished && d.done > 0 && d.total > d.done {
+		perRepo := now.Sub(d.started) / time.Duration(d.done)
+		eta := now.Add(perRepo * time.Duration(d.total-d.done)).Truncate(time.Second)
+		p.ETA = &eta
+	}
+	for _, ws := range d.workers {
+		if ws.did != "" {
+			p.InFlight = append(p.InFlight, ProgressRepo{DID: ws.did, Phase: ws.phase, Records: ws.records, Blobs: ws.blobs, Since: ws.since})
+		}
+	}
+	return p
+}
+
+// saveProgress writes the progress file through a temporary file and a
+// rename, so a reader never sees it half written.
+func (d *dashboard) saveProgress(finished bool) {
+	d.progressSaved = time.Now()
+	b, err := json.MarshalIndent(d.progress(finished), "", "  ")
+	if err != nil {
+		return
+	}
+	if dir := filepath.Dir(d.progressPath); dir != "." {
+		os.MkdirAll(dir, os.ModePerm)
+	}
+	tmp := d.progressPath + ".tmp"
+	err = os.WriteFile(tmp, b, 0666)
+	if err == nil {
+		err = os.Rename(tmp, d.progressPath)
+	}
+	if err != nil {
+		logf("Warning: failed to write %s: %v\n", d.progressPath, err)
+	}
+}
@@ -0,0 +1,134 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipld/go-car/v2"
+)
+
+// Provenance is written as provenance.json in a repo's records directory
+// once every phase has finished, recording where and when the archive came
+// from. PDS is the host the repo was fetched from, which with -pds or
+// -relay isn't the PDS in the DID document; that one is DIDDocPDS.
+type Provenance struct {
+	DID         string                `json:"did"`
+	Handle      string                `json:"handle"`
+	PDS         string                `json:"pds"`
+	DIDDocPDS   string                `json:"did_doc_pds,omitempty"`
+	DIDDocument *identity.DIDDocument `json:"did_document,omitempty"`
+	Commit      repo.SignedCommit     `json:"commit"`
+	CommitCID   string                `json:"commit_cid"`
+	CarPath     string                `json:"car_path"`
+	CarSHA256   string                `json:"car_sha256"`
+	Records     int                   `json:"records"`
+	Blobs       int                   `json:"blobs"`
+	ExtractedAt time.Time             `json:"extracted_at"`
+	ToolVersion string                `json:"tool_version"`
+}
+
+// writeProvenance writes provenance.json for a finished repo, plus a
+// provenance.json.sha256 file in sha256sum format so the record itself can
+// be checked for tampering. host is where the repo was fetched from.
+func writeProvenance(ctx context.Context, ident *identity.Identity, host string, res RepoResult) error {
+	sc, commitCID, carHash, err := scanCar(res.CarPath)
+	if err != nil {
+		return err
+	}
+
+	prov := Provenance{
+		DID:         ident.DID.String(),
+		Handle:      ident.Handle.String(),
+		PDS:         host,
+		DIDDocPDS:   ident.PDSEndpoint(),
+		Commit:      sc,
+		CommitCID:   commitCID,
+		CarPath:     res.CarPath,
+		CarSHA256:   carHash,
+		Records:     res.Records,
+		Blobs:       res.Blobs,
+		ExtractedAt: time.Now().UTC(),
+		ToolVersion: toolVersion(),
+	}
+	var base identity.BaseDirectory
+	if doc, err := base.ResolveDID(ctx, ident.DID); err == nil {
+		prov.DIDDocument = doc
+	} else {
+		logf("Warning: Failed to fetch DID document for provenance: %v\n", err)
+	}
+
+	b, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+	provPath := filepath.Join(res.RecordsPath, "provenance.json")
+	if err := os.WriteFile(provPath, b, 0666); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	line := fmt.Sprintf("%s  provenance.json\n", hex.EncodeToString(sum[:]))
+	return os.WriteFile(provPath+".sha256", []byte(line), 0666)
+}
+
+// scanCar reads a CAR file once, returning its signed commit, the commit's
+// CID and the SHA-256 of the (uncompressed) CAR bytes, without building the
+// whole repo in memory.
+func scanCar(carPath string) (repo.SignedCommit, string, string, error) {
+	fi, err := openCar(carPath)
+	if err != nil {
+		return repo.SignedCommit{}, "", "", err
+	}
+	defer fi.Close()
+	return scanCarReader(fi)
+}
+
+// scanCarReader is scanCar for CAR bytes from r.
+func scanCarReader(r io.Reader) (repo.SignedCommit, string, string, error) {
+	var sc repo.SignedCommit
+	h := sha256.New()
+	tr := io.TeeReader(r, h)
+	br, err := car.NewBlockReader(tr)
+	if err != nil {
+		return sc, "", "", err
+	}
+	if len(br.Roots) == 0 {
+		return sc, "", "", fmt.Errorf("CAR has no root")
+	}
+	root := br.Roots[0]
+
+	found := false
+	for !found {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sc, "", "", err
+		}
+		if blk.Cid().Equals(root) {
+			if err := sc.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+				return sc, "", "", fmt.Errorf("decoding commit: %w", err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return sc, "", "", fmt.Errorf("commit block %s not found in CAR", root)
+	}
+
+	// hash whatever the block reader hasn't consumed yet
+	if _, err := io.Copy(io.Discard, tr); err != nil {
+		return sc, "", "", err
+	}
+	return sc, root.String(), hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,297 @@
+package extractor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Queue entry states. A claimed entry whose lease has run out is treated as
+// pending again, which is how the work of a machine that died is picked up
+// by the others.
+const (
+	queuePending = "pending"
+	queueClaimed = "claimed"
+	queueDone    = "done"
+	queueFailed  = "failed"
+)
+
+const queueSchema = `CREATE TABLE IF NOT EXISTS queue (
+	did         TEXT PRIMARY KEY,
+	status      TEXT NOT NULL DEFAULT 'pending',
+	worker      TEXT,
+	lease_until INTEGER,
+	attempts    INTEGER NOT NULL DEFAULT 0,
+	error       TEXT,
+	updated_at  INTEGER
+)`
+
+// workQueue is a SQLite claim table of DIDs shared by every extractor
+// started with the same -queue, on one machine or several. Each claim is a
+// lease that the claiming worker renews while it processes the repo.
+type workQueue struct {
+	db     *sql.DB
+	worker string
+	lease  time.Duration
+
+	mu sync.Mutex
+	// stop ends the lease renewal of each DID this process holds
+	stop map[string]context.CancelFunc
+}
+
+func openWorkQueue(path string, lease time.Duration) (*workQueue, error) {
+	// a rollback journal rather than WAL, which doesn't work across
+	// machines sharing the file
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(30000)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(queueSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open queue %s: %w", path, err)
+	}
+	host, _ := os.Hostname()
+	return &workQueue{
+		db:     db,
+		worker: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		lease:  lease,
+		stop:   map[string]context.CancelFunc{},
+	}, nil
+}
+
+func (q *workQueue) close() error {
+	return q.db.Close()
+}
+
+// add queues the DIDs that aren't in the queue yet, returning how many
+// were new. DIDs already queued keep their state.
+func (q *workQueue) add(dids []string) (int, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	added := 0
+	now := time.Now().Unix()
+	for _, did := range dids {
+		res, err := tx.Exec("INSERT OR IGNORE INTO queue (did, updated_at) VALUES (?, ?)", did, now)
+		if err != nil {
+			return added, err
+		}
+		n, _ := res.RowsAffected()
+		added += int(n)
+	}
+	return added, tx.Commit()
+}
+
+// claim takes the next pending DID, or one whose lease has expired. While
+// other workers hold live claims it waits for one of them to finish or die,
+// so a worker only gives up once there is nothing left that could become
+// claimable; then it returns ok false.
+func (q *workQueue) claim(ctx context.Context) (did string, ok bool, err error) {
+	poll := min(q.lease/4, 30*time.Second)
+	for {
+		now := time.Now()
+		err := q.db.QueryRowContext(ctx, `UPDATE queue
+			SET status = ?, worker = ?, lease_until = ?, attempts = attempts + 1, updated_at = ?
+			WHERE did = (SELECT did FROM queue
+				WHERE status = ? OR (status = ? AND lease_until < ?)
+				ORDER BY rowid LIMIT 1)
+			RETURNING did`,
+			queueClaimed, q.worker, now.Add(q.lease).Unix(), now.Unix(),
+			queuePending, queueClaimed, now.Unix()).Scan(&did)
+		if err == nil {
+			q.renew(did)
+			return did, true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", false, err
+		}
+
+		var held int
+		if err := q.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM queue WHERE status = ?", queueClaimed).Scan(&held); err != nil {
+			return "", false, err
+		}
+		if held == 0 {
+			return "", false, nil
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+}
+
+// renew keeps extending the lease on did until finish or release is called,
+// so a repo that takes longer than the lease isn't handed to another worker.
+func (q *workQueue) renew(did string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.mu.Lock()
+	q.stop[did] = cancel
+	q.mu.Unlock()
+	go func() {
+		t := time.NewTicker(q.lease / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				_, err := q.db.ExecContext(ctx, "UPDATE queue SET lease_until = ? WHERE did = ? AND worker = ?",
+					time.Now().Add(q.lease).Unix(), did, q.worker)
+				if err != nil && ctx.Err() == nil {
+					logf("Warning: failed to renew queue lease on %s: %v\n", did, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// set moves a DID this worker holds to status, ending its lease.
+func (q *workQueue) set(did, status, errStr string) error {
+	q.mu.Lock()
+	if cancel, ok := q.stop[did]; ok {
+		cancel()
+		delete(q.stop, did)
+	}
+	q.mu.Unlock()
+	_, err := q.db.Exec(`UPDATE queue SET status = ?, error = ?, worker = NULL, lease_until = NULL, updated_at = ?
+		WHERE did = ? AND worker = ?`, status, errStr, time.Now().Unix(), did, q.worker)
+	return err
+}
+
+// finish records the outcome of a claimed DID. Repos that failed because
+// the run was interrupted, or were locked by another extractor, go back to
+// pending to be claimed again.
+func (q *workQueue) finish(did string, res RepoResult, interrupted bool) error {
+	switch {
+	case res.Status == StatusOK:
+		return q.set(did, queueDone, "")
+	case interrupted || res.Status == StatusLocked:
+		return q.set(did, queuePending, "")
+	default:
+		return q.set(did, queueFailed, res.Error)
+	}
+}
+
+// release hands a claimed DID that was never started back to the queue.
+func (q *workQueue) release(did string) error {
+	return q.set(did, queuePending, "")
+}
+
+// retryFailed puts every failed DID back to pending.
+func (q *workQueue) retryFailed() (int, error) {
+	res, err := q.db.Exec("UPDATE queue SET status = ?, error = NULL, updated_at = ? WHERE status = ?",
+		queuePending, time.Now().Unix(), queueFailed)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// counts returns how many DIDs are in each state. Claims whose lease has
+// expired are counted as pending.
+func (q *workQueue) counts() (map[string]int, error) {
+	rows, err := q.db.Query(`SELECT CASE WHEN status = ? AND lease_until < ? THEN ? ELSE status END, COUNT(*)
+		FROM queue GROUP BY 1`, queueClaimed, time.Now().Unix(), queuePending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		out[status] += n
+	}
+	return out, rows.Err()
+}
+
+// openQueueRun opens the -queue of an extraction run and adds the DIDs the
+// run was given, if any, so every worker can be started with the same
+// command line.
+func openQueueRun(config Config, dids []string) (*workQueue, error) {
+	if len(config.Scopes) > 0 {
+		return nil, errors.New("at:// entries of the DIDs file can't be queued; -queue extracts whole repos")
+	}
+	q, err := openWorkQueue(config.Queue, config.QueueLease)
+	if err != nil {
+		return nil, err
+	}
+	if len(dids) > 0 {
+		n, err := q.add(dids)
+		if err != nil {
+			q.close()
+			return nil, fmt.Errorf("failed to fill queue: %w", err)
+		}
+		logf("Queued %d new DIDs in %s\n", n, config.Queue)
+	}
+	return q, nil
+}
+
+// runQueue implements the "queue" subcommand, which fills a -queue database
+// from a DIDs file and prints how far the work has got.
+func runQueue(args []string) error {
+	var addFile string
+	var retry bool
+	fs := flag.NewFlagSet("queue", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s queue [flags] <queue-db>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&addFile, "add", "", "queue the DIDs in this file (one per line), skipping those already queued")
+	fs.BoolVar(&retry, "retry-failed", false, "put the failed DIDs back to pending")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	q, err := openWorkQueue(fs.Arg(0), time.Minute)
+	if err != nil {
+		return err
+	}
+	defer q.close()
+
+	if addFile != "" {
+		dids, err := readDIDsFromFile(addFile)
+		if err != nil {
+			return err
+		}
+		n, err := q.add(dids)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("queued %d new DIDs\n", n)
+	}
+	if retry {
+		n, err := q.retryFailed()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("requeued %d failed DIDs\n", n)
+	}
+
+	counts, err := q.counts()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, status := range []string{queuePending, queueClaimed, queueDone, queueFailed} {
+		fmt.Fprintf(tw, "%s\t%d\n", status, counts[status])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,123 @@
+package extractor
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitTransport reads the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers PDSes send and paces later requests to the same
+// host so the budget lasts until the reset, instead of running into 429s.
+// Once less than a tenth of the limit is left, requests are spread evenly
+// over the time until the reset; with none left they wait for the reset.
+type rateLimitTransport struct {
+	base  http.RoundTripper
+	mu    sync.Mutex
+	hosts map[string]*hostBudget
+}
+
+type hostBudget struct {
+	pace  sync.Mutex // held while a request waits, so the rest queue up
+	mu    sync.Mutex
+	limit int
+	left  int
+	reset time.Time
+	next  time.Time
+}
+
+// pdsClient is the HTTP client for every request to a PDS.
+var pdsClient = &http.Client{Transport: &rateLimitTransport{
+	base:  http.DefaultTransport,
+	hosts: make(map[string]*hostBudget),
+}}
+
+func (t *rateLimitTransport) budget(host string) *hostBudget {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hb, ok := t.hosts[host]
+	if !ok {
+		hb = &hostBudget{}
+		t.hosts[host] = hb
+	}
+	return hb
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hb := t.budget(req.URL.Host)
+	if err := hb.wait(req.Context(), req.URL.Host); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		hb.update(resp.Header, time.Now())
+		resp.Body = bandwidth.wrap(req.Context(), resp.Body)
+	}
+	return resp, err
+}
+
+// wait holds a request back until the host's budget allows it.
+func (hb *hostBudget) wait(ctx context.Context, host string) error {
+	hb.pace.Lock()
+	defer hb.pace.Unlock()
+
+	hb.mu.Lock()
+	now := time.Now()
+	var until time.Time
+	if !hb.reset.IsZero() && now.Before(hb.reset) {
+		switch {
+		case hb.left <= 0:
+			until = hb.reset
+			logf("Rate limit for %s used up; pausing %s until it resets\n", host, until.Sub(now).Round(time.Second))
+		case hb.limit > 0 && hb.left < hb.limit/10:
+			until = maxTime(hb.next, now)
+			hb.next = until.Add(hb.reset.Sub(until) / time.Duration(hb.left+1))
+		}
+		// count this request until a response says otherwise
+		hb.left--
+	}
+	hb.mu.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// update takes the host's remaining budget from a response's headers.
+// Reset is read as a Unix timestamp, as PDSes send it, or as seconds from
+// now for servers following the IETF draft.
+func (hb *hostBudget) update(h http.Header, now time.Time) {
+	left, err := strconv.Atoi(h.Get("RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(h.Get("RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(h.Get("RateLimit-Limit"))
+
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.left = left
+	hb.limit = limit
+	if reset > 1_000_000_000 {
+		hb.reset = time.Unix(reset, 0)
+	} else {
+		hb.reset = now.Add(time.Duration(reset) * time.Second)
+	}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
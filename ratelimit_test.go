@@ -0,0 +1,86 @@
+package extractor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHostBudgetUpdate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		h         http.Header
+		wantLeft  int
+		wantLimit int
+		wantReset time.Time
+	}{
+		{
+			"unix reset",
+			http.Header{"Ratelimit-Limit": {"3000"}, "Ratelimit-Remaining": {"2999"}, "Ratelimit-Reset": {"1717243500"}},
+			2999, 3000, time.Unix(1717243500, 0),
+		},
+		{
+			"delta reset",
+			http.Header{"Ratelimit-Remaining": {"4"}, "Ratelimit-Reset": {"30"}},
+			4, 0, now.Add(30 * time.Second),
+		},
+	}
+	for _, tt := range tests {
+		var hb hostBudget
+		hb.update(tt.h, now)
+		if hb.left != tt.wantLeft || hb.limit != tt.wantLimit || !hb.reset.Equal(tt.wantReset) {
+			t.Errorf("%s: left %d limit %d reset %v; want %d, %d, %v", tt.name, hb.left, hb.limit, hb.reset, tt.wantLeft, tt.wantLimit, tt.wantReset)
+		}
+	}
+
+	// headers missing or malformed leave the budget alone
+	hb := hostBudget{left: 7}
+	hb.update(http.Header{"Ratelimit-Remaining": {"x"}, "Ratelimit-Reset": {"30"}}, now)
+	hb.update(http.Header{"Ratelimit-Remaining": {"1"}}, now)
+	if hb.left != 7 || !hb.reset.IsZero() {
+		t.Errorf("bad headers changed the budget: left %d reset %v", hb.left, hb.reset)
+	}
+}
+
+func TestHostBudgetWait(t *testing.T) {
+	ctx := context.Background()
+
+	// plenty left: no waiting
+	hb := &hostBudget{limit: 100, left: 50, reset: time.Now().Add(time.Hour)}
+	start := time.Now()
+	if err := hb.wait(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("wait with budget left took %v", d)
+	}
+	if hb.left != 49 {
+		t.Errorf("left after a request = %d, want 49", hb.left)
+	}
+
+	// used up: wait for the reset
+	hb = &hostBudget{limit: 100, left: 0, reset: time.Now().Add(50 * time.Millisecond)}
+	start = time.Now()
+	if err := hb.wait(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("wait with no budget returned after %v, before the reset", d)
+	}
+
+	// a past reset no longer holds requests back
+	hb = &hostBudget{limit: 100, left: 0, reset: time.Now().Add(-time.Second)}
+	if err := hb.wait(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelled while waiting
+	hb = &hostBudget{limit: 100, left: 0, reset: time.Now().Add(time.Hour)}
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := hb.wait(short, "a"); err != context.DeadlineExceeded {
+		t.Errorf("cancelled wait = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,36 @@
+package extractor
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// recordCutoff returns the time before which -max-record-age skips records,
+// or the zero time when every record is kept.
+func recordCutoff(config Config) time.Time {
+	if config.MaxRecordAge <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-config.MaxRecordAge)
+}
+
+// tooOld reports whether the record at key k has a TID rkey from before
+// cutoff. The TID is the record's creation time as its author's client
+// chose it, so this needs no decoding of the record. Records with other
+// rkeys (like a profile's "self") are never too old.
+func tooOld(k string, cutoff time.Time) bool {
+	if cutoff.IsZero() {
+		return false
+	}
+	_, rkey, ok := strings.Cut(k, "/")
+	if !ok {
+		return false
+	}
+	tid, err := syntax.ParseTID(rkey)
+	if err != nil {
+		return false
+	}
+	return tid.Time().Before(cutoff)
+}
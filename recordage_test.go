@@ -0,0 +1,41 @@
+package extractor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+func TestTooOld(t *testing.T) {
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := syntax.NewTIDFromTime(cutoff.Add(-time.Hour), 0).String()
+	after := syntax.NewTIDFromTime(cutoff.Add(time.Hour), 0).String()
+
+	tests := []struct {
+		k      string
+		cutoff time.Time
+		want   bool
+	}{
+		{"app.bsky.feed.post/" + before, cutoff, true},
+		{"app.bsky.feed.post/" + after, cutoff, false},
+		{"app.bsky.feed.post/" + before, time.Time{}, false},
+		{"app.bsky.actor.profile/self", cutoff, false},
+		{"app.bsky.feed.post", cutoff, false},
+	}
+	for _, tt := range tests {
+		if got := tooOld(tt.k, tt.cutoff); got != tt.want {
+			t.Errorf("tooOld(%q, %v) = %v, want %v", tt.k, tt.cutoff, got, tt.want)
+		}
+	}
+}
+
+func TestRecordCutoff(t *testing.T) {
+	if c := recordCutoff(Config{}); !c.IsZero() {
+		t.Errorf("recordCutoff without -max-record-age = %v, want zero", c)
+	}
+	c := recordCutoff(Config{MaxRecordAge: 24 * time.Hour})
+	if d := time.Since(c); d < 24*time.Hour || d > 25*time.Hour {
+		t.Errorf("recordCutoff(24h) is %v ago", d)
+	}
+}
@@ -0,0 +1,99 @@
+package extractor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// redactor drops or hashes fields of each record before it is written, for
+// -drop-fields and -hash-fields. Paths are dotted as in -filter, with "[]"
+// descending into every element of an array. A nil *redactor leaves records
+// untouched.
+type redactor struct {
+	drop [][]string
+	hash [][]string
+}
+
+func newRedactor(drop, hash string) *redactor {
+	rd := &redactor{drop: splitFieldPaths(drop), hash: splitFieldPaths(hash)}
+	if len(rd.drop) == 0 && len(rd.hash) == 0 {
+		return nil
+	}
+	return rd
+}
+
+func splitFieldPaths(list string) [][]string {
+	var paths [][]string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, strings.Split(strings.TrimPrefix(p, "."), "."))
+		}
+	}
+	return paths
+}
+
+// apply returns the record in generic form with the configured fields
+// removed or replaced by "sha256:<hex>", and how many fields it changed.
+func (rd *redactor) apply(rec any) (any, int, error) {
+	if rd == nil {
+		return rec, 0, nil
+	}
+	generic, err := toGeneric(rec)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := 0
+	for _, path := range rd.drop {
+		n += editField(generic, path, func(m map[string]any, key string) {
+			delete(m, key)
+		})
+	}
+	for _, path := range rd.hash {
+		n += editField(generic, path, func(m map[string]any, key string) {
+			m[key] = hashField(m[key])
+		})
+	}
+	return generic, n, nil
+}
+
+// editField calls fn on every object holding the last element of path and
+// returns the number of calls.
+func editField(v any, path []string, fn func(m map[string]any, key string)) int {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return 0
+	}
+	name, each := strings.CutSuffix(path[0], "[]")
+	child, ok := m[name]
+	if !ok {
+		return 0
+	}
+	if len(path) == 1 {
+		fn(m, name)
+		return 1
+	}
+	if !each {
+		return editField(child, path[1:], fn)
+	}
+	arr, _ := child.([]any)
+	n := 0
+	for _, elem := range arr {
+		n += editField(elem, path[1:], fn)
+	}
+	return n
+}
+
+// hashField returns "sha256:" and the hex SHA-256 of a string's bytes, or of
+// the JSON encoding of any other value.
+func hashField(v any) string {
+	var b []byte
+	if s, ok := v.(string); ok {
+		b = []byte(s)
+	} else {
+		b, _ = json.Marshal(v)
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
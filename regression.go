@@ -0,0 +1,93 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RevRegressionError is a repo whose PDS served an older rev than the one
+// already archived, as after a rollback or a restore from backup. It is
+// returned before the archive is overwritten, unless -force is given.
+type RevRegressionError struct {
+	Archived string
+	Served   string
+}
+
+func (e *RevRegressionError) Error() string {
+	return fmt.Sprintf("PDS served rev %s, older than the archived rev %s (-force overwrites the archive anyway)", e.Served, e.Archived)
+}
+
+// RevRegression is a line of _rev_regressions.ndjson.
+type RevRegression struct {
+	DetectedAt  time.Time `json:"detected_at"`
+	PDS         string    `json:"pds,omitempty"`
+	ArchivedRev string    `json:"archived_rev"`
+	ServedRev   string    `json:"served_rev"`
+	// Forced is whether the archive was overwritten anyway, with -force
+	Forced bool `json:"forced"`
+}
+
+// archivedRev returns the newest rev archived for did: that of its
+// _commit.json at commitPath, or the index's if it is newer.
+func archivedRev(commitPath, did string) string {
+	var rev string
+	var commit struct {
+		Rev string `json:"rev"`
+	}
+	if b, err := os.ReadFile(commitPath); err == nil && json.Unmarshal(b, &commit) == nil {
+		rev = commit.Rev
+	}
+	if r := archiveIndex.rev(did); r > rev {
+		rev = r
+	}
+	return rev
+}
+
+// checkServedRev fails with a *RevRegressionError when carBytes holds an
+// older rev than archivedRev and -force isn't set. Revs are TIDs, which
+// sort in time order.
+func checkServedRev(carBytes []byte, archivedRev string, config Config) error {
+	if archivedRev == "" || config.Force {
+		return nil
+	}
+	sc, _, _, err := scanCarReader(bytes.NewReader(carBytes))
+	if err != nil {
+		// left for readCarRoot to report
+		return nil
+	}
+	if sc.Rev != "" && sc.Rev < archivedRev {
+		return &RevRegressionError{Archived: archivedRev, Served: sc.Rev}
+	}
+	return nil
+}
+
+// recordRevRegression warns about a rev regression and appends it to the
+// repo's _rev_regressions.ndjson.
+func recordRevRegression(recordsPath, did, pds string, reg RevRegression) {
+	if reg.Forced {
+		logf("Warning: %s served %s at rev %s, older than the archived %s; overwriting the archive (-force)\n", pds, did, reg.ServedRev, reg.ArchivedRev)
+	} else {
+		logf("Warning: %s served %s at rev %s, older than the archived %s; keeping the archive\n", pds, did, reg.ServedRev, reg.ArchivedRev)
+	}
+	reg.DetectedAt = time.Now().UTC()
+	reg.PDS = pds
+	b, err := json.Marshal(reg)
+	if err == nil {
+		os.MkdirAll(recordsPath, os.ModePerm)
+		var f *os.File
+		f, err = os.OpenFile(filepath.Join(recordsPath, "_rev_regressions.ndjson"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err == nil {
+			_, err = f.Write(append(b, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		logf("Warning: failed to record the rev regression: %v\n", err)
+	}
+}
@@ -0,0 +1,21 @@
+package extractor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrRepoLocked is returned by processRepo when another worker or extractor
+// process holds the repo's output lock.
+var ErrRepoLocked = errors.New("output directory is locked by another extractor")
+
+// lockRepoOutput takes the output lock of a repo, a _lock file in its
+// records directory (or next to its CAR when nothing is written there;
+// see writesRecordsDir). It returns
+// ErrRepoLocked straight away rather than waiting if the lock is held,
+// and a function that releases it.
+func lockRepoOutput(path string) (func(), error) {
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	return lockFile(path)
+}
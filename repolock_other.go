@@ -0,0 +1,24 @@
+//go:build !unix
+
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// lockFile creates path exclusively. Without flock a lock left behind by a
+// crashed process has to be removed by hand.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrRepoLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	f.Close()
+	return func() { os.Remove(path) }, nil
+}
@@ -0,0 +1,29 @@
+package extractor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockRepoOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "did:plc:abc", "_lock")
+	unlock, err := lockRepoOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockRepoOutput(path); !errors.Is(err, ErrRepoLocked) {
+		t.Fatalf("second lock = %v, want ErrRepoLocked", err)
+	}
+	unlock()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file left behind after unlock: %v", err)
+	}
+
+	unlock, err = lockRepoOutput(path)
+	if err != nil {
+		t.Fatalf("relocking after unlock: %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an flock on path, so a process that dies releases it with
+// no stale lockfile left to clean up. The file is removed on release; since
+// another process may have locked the old file just before, a lock only
+// counts if path still names the file that was locked.
+func lockFile(path string) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, ErrRepoLocked
+			}
+			return nil, err
+		}
+		held, err1 := f.Stat()
+		named, err2 := os.Stat(path)
+		if err1 == nil && err2 == nil && os.SameFile(held, named) {
+			f.Truncate(0)
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return func() {
+				os.Remove(path)
+				f.Close()
+			}, nil
+		}
+		// removed by its previous holder after we opened it; try again
+		f.Close()
+	}
+}
@@ -0,0 +1,113 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Report formats accepted by -report-format.
+const (
+	ReportText = "text"
+	ReportJSON = "json"
+)
+
+// RunReport is the end-of-run summary.
+type RunReport struct {
+	Total            int          `json:"total"`
+	OK               int          `json:"ok"`
+	Failed           int          `json:"failed"`
+	Unsupported      int          `json:"unsupported"`
+	Locked           int          `json:"locked,omitempty"`
+	PostCmdFailed    int          `json:"post_cmd_failed,omitempty"`
+	HandleUnverified int          `json:"handle_unverified,omitempty"`
+	MissingBlobs     int          `json:"missing_blobs,omitempty"`
+	BrokenHosts      []string     `json:"broken_hosts,omitempty"`
+	Interrupted      bool         `json:"interrupted,omitempty"`
+	Repos            []RepoResult `json:"repos"`
+}
+
+func (rr *RunReport) add(res RepoResult) {
+	rr.Repos = append(rr.Repos, res)
+	switch res.Status {
+	case StatusOK:
+		rr.OK++
+	case StatusError, StatusRecordError, StatusRevRegression:
+		rr.Failed++
+	case StatusUnsupported:
+		rr.Unsupported++
+	case StatusLocked:
+		rr.Locked++
+	}
+	if res.PostCmdError != "" {
+		rr.PostCmdFailed++
+	}
+	if res.Handle != "" && !res.HandleVerified {
+		rr.HandleUnverified++
+	}
+	rr.MissingBlobs += len(res.MissingBlobs)
+}
+
+// writeRunReport prints the report in the configured format, to
+// config.ReportFile if set. Text reports otherwise go to the log and JSON
+// reports to stdout; with -tui both go to stdout, under the dashboard.
+func writeRunReport(rr *RunReport, config Config) error {
+	var w io.Writer
+	switch {
+	case config.ReportFile != "":
+		f, err := os.Create(config.ReportFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	case config.ReportFormat == ReportJSON, config.TUI:
+		w = os.Stdout
+	default:
+		w = logw
+	}
+
+	switch config.ReportFormat {
+	case ReportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rr)
+	case ReportText, "":
+		fmt.Fprintf(w, "Done: %d repos, %d ok, %d failed, %d unsupported DID method\n",
+			rr.Total, rr.OK, rr.Failed, rr.Unsupported)
+		if rr.Interrupted {
+			fmt.Fprintf(w, "  interrupted after %d of %d repos\n", len(rr.Repos), rr.Total)
+		}
+		if rr.Locked > 0 {
+			fmt.Fprintf(w, "  %d repos skipped, locked by another extractor\n", rr.Locked)
+		}
+		if rr.PostCmdFailed > 0 {
+			fmt.Fprintf(w, "  post command failed for %d repos\n", rr.PostCmdFailed)
+		}
+		for _, res := range rr.Repos {
+			if res.Handle != "" && !res.HandleVerified {
+				fmt.Fprintf(w, "  handle not verified: %s\t%s\n", res.DID, res.DeclaredHandle)
+			}
+		}
+		if rr.MissingBlobs > 0 {
+			fmt.Fprintf(w, "  %d listed blobs were missing on their PDS and skipped\n", rr.MissingBlobs)
+		}
+		for _, res := range rr.Repos {
+			for _, c := range res.MissingBlobs {
+				fmt.Fprintf(w, "  missing blob: %s\t%s\n", res.DID, c)
+			}
+		}
+		for _, host := range rr.BrokenHosts {
+			fmt.Fprintf(w, "  circuit broken: %s\n", host)
+		}
+		for _, res := range rr.Repos {
+			if res.Status != StatusOK {
+				fmt.Fprintf(w, "  %s\t%s\t%s\n", res.Status, res.DID, res.Error)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown report format %q", config.ReportFormat)
+	}
+}
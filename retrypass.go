@@ -0,0 +1,86 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// transientError reports whether a repo that failed with err may well
+// succeed if tried again later: network failures, timeouts, rate limits
+// and server errors, as opposed to problems with the repo itself. A host
+// whose circuit is open only counts when -breaker-cooldown will let it be
+// tried again.
+func transientError(err error, config Config) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		return config.BreakerCooldown > 0
+	}
+	var xerr *xrpc.Error
+	if errors.As(err, &xerr) {
+		return xerr.StatusCode == http.StatusTooManyRequests || xerr.StatusCode >= 500
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, errNoProgress) ||
+		errors.Is(err, identity.ErrDIDResolutionFailed) ||
+		errors.Is(err, identity.ErrHandleResolutionFailed)
+}
+
+// retryFailedPasses extracts the repos of report that failed transiently
+// again, up to config.RetryFailedPasses times or until none are left, and
+// returns the report with their latest results. Each result is also passed
+// to handle. Repos a pass didn't get to, as when interrupted, keep their
+// earlier result.
+func retryFailedPasses(ctx context.Context, config Config, report RunReport, handle func(RepoResult)) RunReport {
+	for pass := 1; pass <= config.RetryFailedPasses && ctx.Err() == nil; pass++ {
+		var dids []string
+		failed := map[string]RepoResult{}
+		next := RunReport{Total: report.Total}
+		for _, res := range report.Repos {
+			if res.transient {
+				dids = append(dids, res.given)
+				failed[res.given] = res
+			} else {
+				next.add(res)
+			}
+		}
+		if len(dids) == 0 {
+			break
+		}
+
+		logf("Retrying %d repos that failed transiently (pass %d of %d)\n", len(dids), pass, config.RetryFailedPasses)
+		dash.grow(len(dids))
+		start := time.Now()
+		for res := range extractAll(ctx, config, dids) {
+			handle(res)
+			next.add(res)
+			delete(failed, res.given)
+		}
+		for _, did := range dids {
+			if res, ok := failed[did]; ok {
+				next.add(res)
+			}
+		}
+		still := 0
+		for _, res := range next.Repos {
+			if res.Status != StatusOK {
+				still++
+			}
+		}
+		logf("Retry pass %d took %s; %d repos still failed\n", pass, time.Since(start).Truncate(time.Second), still)
+		report = next
+	}
+	return report
+}
@@ -0,0 +1,43 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestTransientError(t *testing.T) {
+	cooldown := Config{BreakerCooldown: 1}
+	tests := []struct {
+		name   string
+		err    error
+		config Config
+		want   bool
+	}{
+		{"rate limited", &xrpc.Error{StatusCode: http.StatusTooManyRequests}, Config{}, true},
+		{"server error", fmt.Errorf("getRepo: %w", &xrpc.Error{StatusCode: http.StatusBadGateway}), Config{}, true},
+		{"repo not found", &xrpc.Error{StatusCode: http.StatusBadRequest}, Config{}, false},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, Config{}, true},
+		{"timeout", context.DeadlineExceeded, Config{}, true},
+		{"cut off", io.ErrUnexpectedEOF, Config{}, true},
+		{"no progress", fmt.Errorf("%w: reset", errNoProgress), Config{}, true},
+		{"DID resolution", identity.ErrDIDResolutionFailed, Config{}, true},
+		{"cancelled", context.Canceled, Config{}, false},
+		{"bad CAR", errors.New("invalid CAR header"), Config{}, false},
+		{"empty repo", ErrEmptyRepo, Config{}, false},
+		{"circuit open", &CircuitOpenError{Host: "a"}, Config{}, false},
+		{"circuit open with cooldown", &CircuitOpenError{Host: "a"}, cooldown, true},
+	}
+	for _, tt := range tests {
+		if got := transientError(tt.err, tt.config); got != tt.want {
+			t.Errorf("%s: transientError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
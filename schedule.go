@@ -0,0 +1,109 @@
+package extractor
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+)
+
+// Scheduling strategies accepted by -schedule.
+const (
+	ScheduleList         = "list"
+	ScheduleLargestFirst = "largest-first"
+)
+
+// sizeEstimateTimeout bounds the getRepo HEAD request of one estimate.
+const sizeEstimateTimeout = 10 * time.Second
+
+// scheduleOrder returns the order in which to process dids under
+// config.Schedule, as indexes into dids. Largest-first starts the biggest
+// repos while there is still other work to run beside them, so a few giant
+// repos don't finish long after everything else. Repos whose size can't be
+// estimated keep their list order, after the others.
+func scheduleOrder(ctx context.Context, config Config, dids []string) []int {
+	order := make([]int, len(dids))
+	for i := range order {
+		order[i] = i
+	}
+	if config.Schedule != ScheduleLargestFirst || len(dids) < 2 {
+		return order
+	}
+
+	sizes := make([]int64, len(dids))
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	dir := identity.DefaultDirectory()
+	for w := 0; w < max(config.Concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sizes[i] = estimateRepoSize(ctx, dir, dids[i], config)
+			}
+		}()
+	}
+	for i := range dids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	known := 0
+	for _, n := range sizes {
+		if n >= 0 {
+			known++
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return sizes[order[a]] > sizes[order[b]]
+	})
+	logf("Estimated the size of %d of %d repos; scheduling the largest first\n", known, len(dids))
+	if known > 0 {
+		logf("Largest: %s (%s)\n", dids[order[0]], formatBytes(sizes[order[0]]))
+	}
+	return order
+}
+
+// estimateRepoSize guesses the CAR size of did: its -from-cars CAR, or the
+// CAR kept from an earlier run if there is one, otherwise the
+// Content-Length the PDS gives for a getRepo HEAD request. It returns -1
+// when neither is available, as with PDSes that stream repos without a
+// length.
+func estimateRepoSize(ctx context.Context, dir identity.Directory, did string, config Config) int64 {
+	if carPath, ok := config.LocalCars[did]; ok {
+		if fi, err := os.Stat(carPath); err == nil {
+			return fi.Size()
+		}
+		return -1
+	}
+	if fi, err := os.Stat(filepath.Join(config.CarsDir, carFileName(did, config))); err == nil {
+		return fi.Size()
+	}
+	host, err := resolvePDS(ctx, dir, did, config)
+	if err != nil {
+		return -1
+	}
+	ctx, cancel := context.WithTimeout(ctx, sizeEstimateTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, host+"/xrpc/com.atproto.sync.getRepo?did="+url.QueryEscape(did), nil)
+	if err != nil {
+		return -1
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+	resp, err := pdsClient.Do(req)
+	if err != nil {
+		return -1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 {
+		return -1
+	}
+	return resp.ContentLength
+}
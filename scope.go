@@ -0,0 +1,60 @@
+package extractor
+
+import (
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// scopeEntries splits at:// entries of the DIDs file into their account and
+// the collection or record they select. It returns one entry per account,
+// in first-seen order, and the scopes keyed by that entry. An account listed
+// plainly anywhere in the input has no scope and is extracted in full.
+// Entries that don't parse as at:// URIs are passed through unchanged.
+func scopeEntries(entries []string) ([]string, map[string][]string) {
+	var targets []string
+	scopes := map[string][]string{}
+	full := map[string]bool{}
+	seen := map[string]bool{}
+
+	for _, entry := range entries {
+		target := entry
+		scope := ""
+		if strings.HasPrefix(entry, "at://") {
+			if u, err := syntax.ParseATURI(entry); err == nil {
+				target = u.Authority().String()
+				scope = u.Collection().String()
+				if rkey := u.RecordKey().String(); rkey != "" {
+					scope += "/" + rkey
+				}
+			}
+		}
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+		if scope == "" {
+			full[target] = true
+			continue
+		}
+		scopes[target] = append(scopes[target], scope)
+	}
+	for target := range full {
+		delete(scopes, target)
+	}
+	return targets, scopes
+}
+
+// inScope reports whether the record key k ("<collection>/<rkey>") is
+// selected by scope. An empty scope selects everything.
+func inScope(scope []string, k string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+	for _, s := range scope {
+		if k == s || strings.HasPrefix(k, s+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package extractor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInScope(t *testing.T) {
+	tests := []struct {
+		scope []string
+		k     string
+		want  bool
+	}{
+		{nil, "app.bsky.feed.post/1", true},
+		{[]string{"app.bsky.feed.post"}, "app.bsky.feed.post/1", true},
+		{[]string{"app.bsky.feed.post"}, "app.bsky.feed.postgate/1", false},
+		{[]string{"app.bsky.feed.post"}, "app.bsky.feed.like/1", false},
+		{[]string{"app.bsky.feed.post/1"}, "app.bsky.feed.post/1", true},
+		{[]string{"app.bsky.feed.post/1"}, "app.bsky.feed.post/12", false},
+		{[]string{"app.bsky.feed.like", "app.bsky.actor.profile/self"}, "app.bsky.actor.profile/self", true},
+	}
+	for _, tt := range tests {
+		if got := inScope(tt.scope, tt.k); got != tt.want {
+			t.Errorf("inScope(%q, %q) = %v, want %v", tt.scope, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestScopeEntries(t *testing.T) {
+	targets, scopes := scopeEntries([]string{
+		"at://did:plc:a/app.bsky.feed.post",
+		"did:plc:b",
+		"at://did:plc:a/app.bsky.actor.profile/self",
+		"at://did:plc:b/app.bsky.feed.like",
+	})
+	if want := []string{"did:plc:a", "did:plc:b"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets = %q, want %q", targets, want)
+	}
+	want := map[string][]string{"did:plc:a": {"app.bsky.feed.post", "app.bsky.actor.profile/self"}}
+	if !reflect.DeepEqual(scopes, want) {
+		t.Errorf("scopes = %q, want %q", scopes, want)
+	}
+}
@@ -0,0 +1,120 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/gorilla/websocket"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// DefaultSeqRelay is the relay whose firehose position -record-seq notes.
+const DefaultSeqRelay = "https://bsky.network"
+
+// relaySeqTimeout bounds the wait for the first firehose frame.
+const relaySeqTimeout = 15 * time.Second
+
+// SeqPosition is written as _seq.json with -record-seq. It ties an
+// extraction to the PDS's latest commit and to the relay's firehose: the
+// position was taken just before the repo was downloaded, so every event
+// for the repo with a lower seq is in the archive, if the relay and PDS
+// agree.
+type SeqPosition struct {
+	PDS           string    `json:"pds"`
+	LatestCID     string    `json:"latest_cid"`
+	LatestRev     string    `json:"latest_rev"`
+	Relay         string    `json:"relay"`
+	RelaySeq      int64     `json:"relay_seq"`
+	RecordedAt    time.Time `json:"recorded_at"`
+	ArchivedRev   string    `json:"archived_rev,omitempty"`
+	MatchesLatest bool      `json:"matches_latest"`
+}
+
+// recordSeqPosition asks relay for the seq of its next firehose event, then
+// host for did's latest commit.
+func recordSeqPosition(ctx context.Context, host, did, relay string) (SeqPosition, error) {
+	pos := SeqPosition{PDS: host, Relay: relay, RecordedAt: time.Now().UTC()}
+	seq, err := relaySeq(ctx, relay)
+	if err != nil {
+		return pos, fmt.Errorf("reading firehose of %s: %w", relay, err)
+	}
+	pos.RelaySeq = seq
+
+	var out *comatproto.SyncGetLatestCommit_Output
+	err = session.withClient(ctx, host, func(c *xrpc.Client) error {
+		var err error
+		out, err = comatproto.SyncGetLatestCommit(ctx, c, did)
+		return err
+	})
+	if err != nil {
+		return pos, fmt.Errorf("getting latest commit: %w", err)
+	}
+	pos.LatestCID, pos.LatestRev = out.Cid, out.Rev
+	return pos, nil
+}
+
+// relaySeq connects to relay's com.atproto.sync.subscribeRepos without a
+// cursor and returns the seq of the first event it sends, which is the
+// relay's current position.
+func relaySeq(ctx context.Context, relay string) (int64, error) {
+	u := strings.TrimSuffix(relay, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+	u = "ws" + strings.TrimPrefix(u, "http")
+	ctx, cancel := context.WithTimeout(ctx, relaySeqTimeout)
+	defer cancel()
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(dl)
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bytes.NewReader(msg)
+	var hdr, body cbg.Deferred
+	if err := hdr.UnmarshalCBOR(r); err != nil {
+		return 0, err
+	}
+	var h frameHeader
+	if err := cbor.DecodeInto(hdr.Raw, &h); err != nil {
+		return 0, err
+	}
+	if err := body.UnmarshalCBOR(r); err != nil {
+		return 0, err
+	}
+	var fields map[string]any
+	if err := cbor.DecodeInto(body.Raw, &fields); err != nil {
+		return 0, err
+	}
+	if h.Op != 1 {
+		return 0, fmt.Errorf("relay sent an error frame: %v", fields["message"])
+	}
+	switch seq := fields["seq"].(type) {
+	case int:
+		return int64(seq), nil
+	case int64:
+		return seq, nil
+	case uint64:
+		return int64(seq), nil
+	default:
+		return 0, fmt.Errorf("%s frame has no seq", h.T)
+	}
+}
+
+// writeSeqFile saves pos as _seq.json, noting the rev that was archived.
+func writeSeqFile(recordsPath string, pos SeqPosition, archivedRev string) error {
+	pos.ArchivedRev = archivedRev
+	pos.MatchesLatest = archivedRev == pos.LatestRev
+	return writeJSONFile(filepath.Join(recordsPath, "_seq.json"), pos)
+}
@@ -0,0 +1,141 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// authSession holds the tokens from an app-password login and refreshes
+// them when the access token expires. It is only ever used against the
+// account's own PDS. A nil *authSession makes unauthenticated requests.
+type authSession struct {
+	mu   sync.Mutex
+	host string
+	auth xrpc.AuthInfo
+	// gen counts refreshes, so that when several requests see an expired
+	// token at once only the first one refreshes it.
+	gen int
+}
+
+// session is the logged-in account, when credentials are configured.
+var session *authSession
+
+// newAuthSession logs in with an app password via
+// com.atproto.server.createSession on the identifier's PDS.
+func newAuthSession(ctx context.Context, identifier, password string) (*authSession, error) {
+	atid, err := syntax.ParseAtIdentifier(identifier)
+	if err != nil {
+		return nil, err
+	}
+	ident, err := identity.DefaultDirectory().Lookup(ctx, *atid)
+	if err != nil {
+		return nil, err
+	}
+	host := ident.PDSEndpoint()
+	if host == "" {
+		return nil, fmt.Errorf("no PDS endpoint for identity")
+	}
+
+	out, err := comatproto.ServerCreateSession(ctx, &xrpc.Client{Host: host}, &comatproto.ServerCreateSession_Input{
+		Identifier: identifier,
+		Password:   password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return &authSession{
+		host: host,
+		auth: xrpc.AuthInfo{
+			AccessJwt:  out.AccessJwt,
+			RefreshJwt: out.RefreshJwt,
+			Handle:     out.Handle,
+			Did:        out.Did,
+		},
+	}, nil
+}
+
+// withClient calls fn with a client for host. Requests to the session's own
+// PDS are authenticated, and if the access token has expired the session is
+// refreshed and fn is retried once.
+func (s *authSession) withClient(ctx context.Context, host string, fn func(*xrpc.Client) error) error {
+	if s == nil || host != s.host {
+		return fn(&xrpc.Client{Client: pdsClient, Host: host})
+	}
+
+	c, gen := s.client()
+	err := fn(c)
+	if !isExpiredToken(err) {
+		return err
+	}
+	if err := s.refresh(ctx, gen); err != nil {
+		return err
+	}
+	c, _ = s.client()
+	return fn(c)
+}
+
+// did returns the logged-in account's DID, or "" without a session.
+func (s *authSession) did() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auth.Did
+}
+
+func (s *authSession) client() (*xrpc.Client, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	auth := s.auth
+	return &xrpc.Client{Client: pdsClient, Host: s.host, Auth: &auth}, s.gen
+}
+
+// refresh swaps the refresh JWT for new tokens via
+// com.atproto.server.refreshSession, unless another request already did so
+// since gen was observed.
+func (s *authSession) refresh(ctx context.Context, gen int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen != gen {
+		return nil
+	}
+
+	// refreshSession authenticates with the refresh token as the bearer
+	c := &xrpc.Client{Host: s.host, Auth: &xrpc.AuthInfo{AccessJwt: s.auth.RefreshJwt}}
+	out, err := comatproto.ServerRefreshSession(ctx, c)
+	if err != nil {
+		return fmt.Errorf("failed to refresh session: %w", err)
+	}
+	s.auth = xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	}
+	s.gen++
+	logf("Refreshed session for %s\n", out.Handle)
+	return nil
+}
+
+// isExpiredToken reports whether err is the PDS rejecting an expired access
+// token. PDSes answer either 401 or 400 with an ExpiredToken error.
+func isExpiredToken(err error) bool {
+	var xerr *xrpc.Error
+	if !errors.As(err, &xerr) {
+		return false
+	}
+	if xerr.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	var body *xrpc.XRPCError
+	return errors.As(err, &body) && body.ErrStr == "ExpiredToken"
+}
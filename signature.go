@@ -0,0 +1,40 @@
+package extractor
+
+import (
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/repo"
+)
+
+// CommitSignature is the outcome of checking a repo's commit signature
+// against the atproto signing key in its DID document, added to
+// _commit.json with -verify-signatures.
+type CommitSignature struct {
+	// SigningKey is the did:key the signature was checked against
+	SigningKey string `json:"signingKey,omitempty"`
+	Valid      bool   `json:"signatureValid"`
+	Error      string `json:"signatureError,omitempty"`
+}
+
+// verifyCommitSignature checks sc's signature with the current signing key
+// of ident. A commit signed before a key rotation no longer verifies, so an
+// invalid signature means the commit can't be trusted as is, not
+// necessarily that it was forged.
+func verifyCommitSignature(ident *identity.Identity, sc repo.SignedCommit) *CommitSignature {
+	cs := &CommitSignature{}
+	key, err := ident.PublicKey()
+	if err != nil {
+		cs.Error = "no usable signing key: " + err.Error()
+		return cs
+	}
+	cs.SigningKey = key.DIDKey()
+	b, err := sc.Unsigned().BytesForSigning()
+	if err == nil {
+		err = key.HashAndVerify(b, sc.Sig)
+	}
+	if err != nil {
+		cs.Error = err.Error()
+		return cs
+	}
+	cs.Valid = true
+	return cs
+}
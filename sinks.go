@@ -0,0 +1,406 @@
+package extractor
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Output formats accepted by -format.
+const (
+	FormatJSON    = "json"
+	FormatMsgpack = "msgpack"
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+	FormatJSONLD  = "jsonld"
+	FormatBolt    = "bolt"
+	FormatNone    = "none"
+)
+
+// defaultWriteBufferSize is the buffer for streamed output files when
+// -write-buffer-size isn't given.
+const defaultWriteBufferSize = 64 << 10
+
+// writeBufferSize returns the buffer size for output written as a stream:
+// msgpack and CSV files, NDJSON and _blob_refs.ndjson. Record files are
+// written with a single call and aren't buffered.
+func writeBufferSize(config Config) int {
+	if config.WriteBufferSize > 0 {
+		return config.WriteBufferSize
+	}
+	return defaultWriteBufferSize
+}
+
+func validFormat(format string) bool {
+	switch format {
+	case FormatJSON, FormatMsgpack, FormatCSV, FormatNDJSON, FormatParquet, FormatJSONLD, FormatBolt, FormatNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// outRecord is one record on its way to an output sink.
+type outRecord struct {
+	URI        string
+	Collection string
+	Rkey       string
+	CID        string
+	Value      any
+}
+
+// recordSink receives every record of a repo when an output format other
+// than the default one-JSON-file-per-record tree is selected.
+type recordSink interface {
+	write(rec outRecord) error
+	close() error
+}
+
+// newRecordSink opens the sink for config.Format in recordsPath. It returns
+// nil for the default JSON file tree, which unpackRepo writes itself.
+func newRecordSink(recordsPath string, config Config) (recordSink, error) {
+	switch config.Format {
+	case FormatJSON, "":
+		return nil, nil
+	case FormatMsgpack:
+		return newMsgpackSink(filepath.Join(recordsPath, "records.msgpack"), writeBufferSize(config))
+	case FormatCSV:
+		fields, err := parseCSVFields(config.Fields)
+		if err != nil {
+			return nil, err
+		}
+		return &csvSink{dir: recordsPath, fields: fields, files: map[string]*csvFile{}, bufSize: writeBufferSize(config)}, nil
+	case FormatNDJSON:
+		return newNDJSONSink(recordsPath, config)
+	case FormatParquet:
+		return &parquetSink{dir: recordsPath, canonical: config.Canonical, files: map[string]*parquetFile{}}, nil
+	case FormatJSONLD:
+		return newJSONLDSink(filepath.Join(recordsPath, "records.jsonld"), config)
+	case FormatBolt:
+		return newBoltSink(filepath.Join(recordsPath, "records.bolt"), config)
+	case FormatNone:
+		return discardSink{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", config.Format)
+	}
+}
+
+// discardSink is -format none: records only go to -sink.
+type discardSink struct{}
+
+func (discardSink) write(outRecord) error { return nil }
+func (discardSink) close() error          { return nil }
+
+// msgpackSink writes a stream of msgpack maps, one per record, each with
+// "uri", "cid" and "value" keys.
+type msgpackSink struct {
+	f   *os.File
+	bw  *bufio.Writer
+	enc *msgpack.Encoder
+}
+
+func newMsgpackSink(path string, bufSize int) (*msgpackSink, error) {
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriterSize(f, bufSize)
+	enc := msgpack.NewEncoder(bw)
+	enc.SetSortMapKeys(true)
+	return &msgpackSink{f: f, bw: bw, enc: enc}, nil
+}
+
+func (ms *msgpackSink) write(rec outRecord) error {
+	generic, err := toGeneric(rec.Value)
+	if err != nil {
+		return err
+	}
+	return ms.enc.Encode(map[string]any{
+		"uri":   rec.URI,
+		"cid":   rec.CID,
+		"value": msgpackNumbers(generic),
+	})
+}
+
+func (ms *msgpackSink) close() error {
+	if err := ms.bw.Flush(); err != nil {
+		ms.f.Close()
+		return err
+	}
+	return ms.f.Close()
+}
+
+// msgpackNumbers replaces json.Number values with int64 or float64 so they
+// are encoded as msgpack numbers rather than strings.
+func msgpackNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = msgpackNumbers(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = msgpackNumbers(e)
+		}
+		return v
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// ndjsonSink writes the record lines of "unpack -o -" to <recordsPath>.ndjson
+// next to the repo's directory. With a size cap it writes numbered shards
+// instead (<recordsPath>.00001.ndjson, ...), starting a new one before a
+// line that would take the current shard past the cap, so no record is ever
+// split. A single line bigger than the cap gets a shard to itself.
+type ndjsonSink struct {
+	base      string
+	maxBytes  int64
+	bufSize   int
+	canonical bool
+
+	shard int
+	size  int64
+	f     *os.File
+	bw    *bufio.Writer
+}
+
+func newNDJSONSink(recordsPath string, config Config) (*ndjsonSink, error) {
+	// shards from an earlier, larger run would otherwise be left behind
+	old, _ := filepath.Glob(recordsPath + ".*.ndjson")
+	for _, p := range append(old, recordsPath+".ndjson") {
+		os.Remove(p)
+	}
+	return &ndjsonSink{
+		base:      recordsPath,
+		maxBytes:  config.MaxOutputFileBytes,
+		bufSize:   writeBufferSize(config),
+		canonical: config.Canonical,
+	}, nil
+}
+
+func (ns *ndjsonSink) write(rec outRecord) error {
+	value := rec.Value
+	if ns.canonical {
+		b, err := canonicalJSON(value)
+		if err != nil {
+			return err
+		}
+		value = json.RawMessage(b)
+	}
+	line, err := json.Marshal(StreamLine{
+		Type:       "record",
+		URI:        rec.URI,
+		Collection: rec.Collection,
+		Rkey:       rec.Rkey,
+		CID:        rec.CID,
+		Value:      value,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if ns.f == nil || (ns.maxBytes > 0 && ns.size > 0 && ns.size+int64(len(line)) > ns.maxBytes) {
+		if err := ns.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := ns.bw.Write(line)
+	ns.size += int64(n)
+	return err
+}
+
+// rotate closes the current file, if any, and opens the next one.
+func (ns *ndjsonSink) rotate() error {
+	if err := ns.close(); err != nil {
+		return err
+	}
+	path := ns.base + ".ndjson"
+	if ns.maxBytes > 0 {
+		ns.shard++
+		path = fmt.Sprintf("%s.%05d.ndjson", ns.base, ns.shard)
+	}
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	ns.f, ns.bw, ns.size = f, bufio.NewWriterSize(f, ns.bufSize), 0
+	return nil
+}
+
+func (ns *ndjsonSink) close() error {
+	if ns.f == nil {
+		return nil
+	}
+	f := ns.f
+	ns.f = nil
+	if err := ns.bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// defaultCSVFields are the columns written for common collections when
+// -fields doesn't name them. Other collections get uri, cid and createdAt.
+var defaultCSVFields = map[string][]string{
+	"app.bsky.feed.post":       {"uri", "cid", "createdAt", "text", "reply.parent.uri", "reply.root.uri", "langs"},
+	"app.bsky.feed.like":       {"uri", "cid", "createdAt", "subject.uri"},
+	"app.bsky.feed.repost":     {"uri", "cid", "createdAt", "subject.uri"},
+	"app.bsky.graph.follow":    {"uri", "cid", "createdAt", "subject"},
+	"app.bsky.graph.block":     {"uri", "cid", "createdAt", "subject"},
+	"app.bsky.actor.profile":   {"uri", "cid", "displayName", "description"},
+	"app.bsky.graph.list":      {"uri", "cid", "createdAt", "name", "purpose", "description"},
+	"app.bsky.graph.listitem":  {"uri", "cid", "createdAt", "list", "subject"},
+	"app.bsky.feed.threadgate": {"uri", "cid", "createdAt", "post"},
+}
+
+var fallbackCSVFields = []string{"uri", "cid", "createdAt"}
+
+// parseCSVFields parses a -fields value of the form
+// "collection=field,field;collection=field" into columns per collection,
+// on top of the defaults.
+func parseCSVFields(spec string) (map[string][]string, error) {
+	fields := make(map[string][]string, len(defaultCSVFields))
+	for k, v := range defaultCSVFields {
+		fields[k] = v
+	}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		collection, cols, ok := strings.Cut(part, "=")
+		if !ok || collection == "" || cols == "" {
+			return nil, fmt.Errorf("invalid -fields entry %q (want collection=field,field)", part)
+		}
+		var list []string
+		for _, c := range strings.Split(cols, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				list = append(list, c)
+			}
+		}
+		fields[strings.TrimSpace(collection)] = list
+	}
+	return fields, nil
+}
+
+// csvSink writes one <collection>.csv per collection, with a header row and
+// the configured columns. The columns uri, cid, collection and rkey come from
+// the record's location; anything else is a dotted path into the record, as
+// in -filter.
+type csvSink struct {
+	dir     string
+	fields  map[string][]string
+	files   map[string]*csvFile
+	bufSize int
+}
+
+type csvFile struct {
+	f    *os.File
+	bw   *bufio.Writer
+	w    *csv.Writer
+	cols []string
+}
+
+func (cs *csvSink) write(rec outRecord) error {
+	cf, ok := cs.files[rec.Collection]
+	if !ok {
+		cols, ok := cs.fields[rec.Collection]
+		if !ok {
+			cols = fallbackCSVFields
+		}
+		os.MkdirAll(cs.dir, os.ModePerm)
+		name, _ := safePathSegment(rec.Collection)
+		f, err := os.Create(filepath.Join(cs.dir, name+".csv"))
+		if err != nil {
+			return err
+		}
+		bw := bufio.NewWriterSize(f, cs.bufSize)
+		cf = &csvFile{f: f, bw: bw, w: csv.NewWriter(bw), cols: cols}
+		cs.files[rec.Collection] = cf
+		if err := cf.w.Write(cols); err != nil {
+			return err
+		}
+	}
+
+	generic, err := toGeneric(rec.Value)
+	if err != nil {
+		return err
+	}
+	row := make([]string, len(cf.cols))
+	for i, col := range cf.cols {
+		switch col {
+		case "uri":
+			row[i] = rec.URI
+		case "cid":
+			row[i] = rec.CID
+		case "collection":
+			row[i] = rec.Collection
+		case "rkey":
+			row[i] = rec.Rkey
+		default:
+			row[i] = csvCell(lookupPath(generic, strings.Split(col, ".")))
+		}
+	}
+	return cf.w.Write(row)
+}
+
+func (cs *csvSink) close() error {
+	var first error
+	for _, cf := range cs.files {
+		cf.w.Flush()
+		if err := cf.w.Error(); err != nil && first == nil {
+			first = err
+		}
+		if err := cf.bw.Flush(); err != nil && first == nil {
+			first = err
+		}
+		if err := cf.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// csvCell renders the values found at a column's path. Scalars are written
+// as-is and anything else, including several matches of a "[]" path, as
+// JSON.
+func csvCell(vals []any) string {
+	if len(vals) == 1 {
+		switch v := vals[0].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		case json.Number:
+			return v.String()
+		case bool:
+			return strconv.FormatBool(v)
+		}
+	}
+	var v any = vals
+	if len(vals) == 1 {
+		v = vals[0]
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
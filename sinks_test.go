@@ -0,0 +1,99 @@
+package extractor
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "did:plc:abc")
+	// a shard left by an earlier run with a smaller cap
+	if err := os.WriteFile(base+".00009.ndjson", []byte("old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	const maxBytes = 300
+	ns, err := newNDJSONSink(base, Config{MaxOutputFileBytes: maxBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		rec := outRecord{URI: "at://did:plc:abc/app.bsky.feed.post/" + strings.Repeat("x", i), Collection: "app.bsky.feed.post", Value: map[string]any{"text": "hello"}}
+		if err := ns.write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// bigger than the cap on its own
+	if err := ns.write(outRecord{URI: "at://did:plc:abc/app.bsky.feed.post/big", Value: map[string]any{"text": strings.Repeat("y", 2*maxBytes)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	shards, _ := filepath.Glob(base + ".*.ndjson")
+	if len(shards) < 3 {
+		t.Fatalf("got shards %q, want at least 3", shards)
+	}
+	lines := 0
+	for i, path := range shards {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := countLines(t, path)
+		lines += n
+		last := i == len(shards)-1
+		if !last && fi.Size() > maxBytes {
+			t.Errorf("%s is %d bytes, over the cap of %d", path, fi.Size(), maxBytes)
+		}
+		if last && n != 1 {
+			t.Errorf("the oversized record shares %s with %d other lines", path, n-1)
+		}
+	}
+	if lines != 11 {
+		t.Errorf("shards hold %d lines, want 11", lines)
+	}
+	if b, err := os.ReadFile(base + ".00009.ndjson"); err == nil && string(b) == "old\n" {
+		t.Error("stale shard from an earlier run was left behind")
+	}
+}
+
+func TestNDJSONSinkUncapped(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "did:plc:abc")
+	ns, err := newNDJSONSink(base, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := ns.write(outRecord{URI: "at://did:plc:abc/app.bsky.feed.post/1", Value: map[string]any{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ns.close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countLines(t, base+".ndjson"); n != 3 {
+		t.Errorf("%s has %d lines, want 3", base+".ndjson", n)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		n++
+	}
+	return n
+}
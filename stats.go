@@ -0,0 +1,99 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// followersNote explains in _stats.json why there is no follower count.
+const followersNote = "followers are records in the followers' own repos, so this repo can't count them"
+
+// RepoStats is _stats.json, aggregates over the records of a repo written
+// with -stats.
+type RepoStats struct {
+	DID       string `json:"did"`
+	Rev       string `json:"rev"`
+	Records   int    `json:"records"`
+	Posts     int    `json:"posts"`
+	Replies   int    `json:"replies"`
+	Reposts   int    `json:"reposts"`
+	Likes     int    `json:"likes"`
+	Follows   int    `json:"follows"`
+	Followers string `json:"followers"`
+	// Earliest and Latest span the records' createdAt times, or their TID
+	// rkeys' where there is no createdAt
+	Earliest     *time.Time     `json:"earliest,omitempty"`
+	Latest       *time.Time     `json:"latest,omitempty"`
+	ByCollection map[string]int `json:"by_collection"`
+}
+
+func newRepoStats(did, rev string) *RepoStats {
+	return &RepoStats{DID: did, Rev: rev, Followers: followersNote, ByCollection: map[string]int{}}
+}
+
+// add counts the record at key k. Posts include replies, which are also
+// counted on their own. A nil *RepoStats counts nothing.
+func (st *RepoStats) add(k, collection string, value any) {
+	if st == nil {
+		return
+	}
+	st.Records++
+	st.ByCollection[collection]++
+
+	m, ok := value.(map[string]any)
+	if !ok {
+		generic, _ := toGeneric(value)
+		m, _ = generic.(map[string]any)
+	}
+	switch collection {
+	case "app.bsky.feed.post":
+		st.Posts++
+		if m["reply"] != nil {
+			st.Replies++
+		}
+	case "app.bsky.feed.repost":
+		st.Reposts++
+	case "app.bsky.feed.like":
+		st.Likes++
+	case "app.bsky.graph.follow":
+		st.Follows++
+	}
+
+	var t time.Time
+	if s, ok := m["createdAt"].(string); ok {
+		if dt, err := syntax.ParseDatetimeLenient(s); err == nil {
+			t = dt.Time()
+		}
+	}
+	if t.IsZero() {
+		_, rkey, _ := strings.Cut(k, "/")
+		if tid, err := syntax.ParseTID(rkey); err == nil {
+			t = tid.Time()
+		}
+	}
+	if t.IsZero() {
+		return
+	}
+	t = t.UTC()
+	if st.Earliest == nil || t.Before(*st.Earliest) {
+		earliest := t
+		st.Earliest = &earliest
+	}
+	if st.Latest == nil || t.After(*st.Latest) {
+		latest := t
+		st.Latest = &latest
+	}
+}
+
+// write saves the stats as _stats.json in recordsPath.
+func (st *RepoStats) write(recordsPath string) error {
+	if st == nil {
+		return nil
+	}
+	os.MkdirAll(recordsPath, os.ModePerm)
+	return writeJSONFile(filepath.Join(recordsPath, "_stats.json"), st)
+}
@@ -0,0 +1,64 @@
+package extractor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// writeSummary writes SUMMARY.md in a finished repo's records directory: a
+// short description of the account and of what was extracted, for someone
+// opening the archive without the tool at hand. collections holds the
+// record counts by collection gathered while unpacking.
+func writeSummary(res RepoResult, collections map[string]int, scope []string, config Config) error {
+	var b strings.Builder
+	title := res.Handle
+	if title == "" || title == "handle.invalid" {
+		title = res.DID
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- Handle: %s", valueOr(res.Handle, "none"))
+	if res.Handle != "" && !res.HandleVerified {
+		b.WriteString(" (doesn't resolve back to the DID)")
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "- DID: `%s`\n", res.DID)
+	fmt.Fprintf(&b, "- PDS: %s\n", valueOr(res.PDS, "unknown"))
+	fmt.Fprintf(&b, "- Repo rev: `%s`\n", valueOr(res.Rev, "unknown"))
+	fmt.Fprintf(&b, "- Records: %d\n", res.Records)
+	if config.DownloadBlobs {
+		fmt.Fprintf(&b, "- Blobs: %d\n", res.Blobs)
+	} else {
+		b.WriteString("- Blobs: not downloaded\n")
+	}
+	fmt.Fprintf(&b, "- Extracted: %s by atproto-car-extractor %s\n", time.Now().UTC().Format(time.RFC3339), toolVersion())
+	if len(scope) > 0 {
+		fmt.Fprintf(&b, "- Limited to: %s\n", strings.Join(scope, ", "))
+	}
+
+	b.WriteString("\n## Records by collection\n\n")
+	if len(collections) == 0 {
+		b.WriteString("No records were extracted.\n")
+	} else {
+		names := make([]string, 0, len(collections))
+		for name := range collections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b.WriteString("| Collection | Records |\n|---|---:|\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "| `%s` | %d |\n", name, collections[name])
+		}
+	}
+	return os.WriteFile(filepath.Join(res.RecordsPath, "SUMMARY.md"), []byte(b.String()), 0666)
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,285 @@
+package extractor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboard is the -tui progress view: one line per worker, an overall
+// progress bar, throughput and the most recent errors, redrawn in place on
+// the terminal. With -progress-file the same state is also saved as JSON;
+// a dashboard without out only does that. A nil *dashboard does nothing, so
+// callers don't need to check whether either is on.
+type dashboard struct {
+	mu      sync.Mutex
+	out     io.Writer
+	started time.Time
+	total   int
+	done    int
+	failed  int
+	bytes   int64
+	workers []workerStatus
+	slots   map[string]int // DID (as given and as resolved) -> worker
+	errors  []string
+	lines   int // lines drawn last time, to move back over them
+
+	progressPath  string
+	progressSaved time.Time
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type workerStatus struct {
+	did     string
+	phase   string
+	records int
+	blobs   int
+	since   time.Time
+}
+
+// dashErrors is how many recent errors the dashboard keeps on screen.
+const dashErrors = 5
+
+// dash is the process-wide dashboard, set up by Main for -tui or by newRun
+// for -progress-file.
+var dash *dashboard
+
+// isTerminal reports whether f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func newDashboard(out io.Writer, workers int) *dashboard {
+	return &dashboard{
+		out:     out,
+		started: time.Now(),
+		workers: make([]workerStatus, max(workers, 1)),
+		slots:   make(map[string]int),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// start sets the number of repos in the run and begins redrawing.
+func (d *dashboard) start(total int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.total = total
+	d.started = time.Now()
+	d.mu.Unlock()
+	if d.out != nil {
+		fmt.Fprint(d.out, "\x1b[?25l") // hide the cursor while redrawing
+	}
+	go func() {
+		defer close(d.stopped)
+		tick := time.NewTicker(250 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			if d.out != nil {
+				d.draw()
+			}
+			if d.progressPath != "" && time.Since(d.progressSaved) >= progressInterval {
+				d.saveProgress(false)
+			}
+			select {
+			case <-tick.C:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close draws the final state and leaves it on the screen.
+func (d *dashboard) close() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	<-d.stopped
+	if d.progressPath != "" {
+		d.saveProgress(true)
+	}
+	if d.out != nil {
+		d.draw()
+		fmt.Fprint(d.out, "\x1b[?25h")
+	}
+}
+
+// grow adds n repos to the total, as a -follow-depth crawl finds them.
+func (d *dashboard) grow(n int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.total += n
+	d.mu.Unlock()
+}
+
+// begin marks worker as processing did.
+func (d *dashboard) begin(worker int, did string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.workers[worker] = workerStatus{did: did, phase: "resolving", since: time.Now()}
+	d.slots[did] = worker
+}
+
+// resolved lets events carrying the resolved DID find the worker that was
+// given a handle.
+func (d *dashboard) resolved(given, did string) {
+	if d == nil || given == did {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if w, ok := d.slots[given]; ok {
+		d.slots[did] = w
+		d.workers[w].did = did
+	}
+}
+
+// end records a finished repo and frees its worker.
+func (d *dashboard) end(worker int, given string, res RepoResult) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.done++
+	if res.Status != StatusOK {
+		d.failed++
+		d.errors = append(d.errors, fmt.Sprintf("%s  %s: %s", time.Now().Format("15:04:05"), given, res.Error))
+		if len(d.errors) > dashErrors {
+			d.errors = d.errors[len(d.errors)-dashErrors:]
+		}
+	}
+	delete(d.slots, given)
+	delete(d.slots, res.DID)
+	d.workers[worker] = workerStatus{}
+}
+
+// setAside frees worker of a repo that will be picked up again later,
+// without counting it as done.
+func (d *dashboard) setAside(worker int, given, did string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.slots, given)
+	delete(d.slots, did)
+	d.workers[worker] = workerStatus{}
+}
+
+// observe updates the worker handling an event's repo. It is called for
+// every event whether or not -events is on.
+func (d *dashboard) observe(ev Event) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.slots[ev.DID]
+	if !ok {
+		return
+	}
+	ws := &d.workers[w]
+	switch ev.Type {
+	case EventCarDownloaded:
+		ws.phase = "unpacking"
+		d.bytes += int64(ev.Bytes)
+	case EventRecordWritten:
+		ws.records++
+	case EventBlobDownloaded:
+		ws.phase = "blobs"
+		ws.blobs++
+		d.bytes += int64(ev.Bytes)
+	case EventRepoDone:
+		ws.phase = "finishing"
+	}
+}
+
+// downloading marks did's worker as fetching its CAR.
+func (d *dashboard) downloading(did string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if w, ok := d.slots[did]; ok {
+		d.workers[w].phase = "downloading"
+	}
+}
+
+func (d *dashboard) draw() {
+	d.mu.Lock()
+	var b strings.Builder
+	if d.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", d.lines)
+	}
+	b.WriteString("\x1b[J")
+
+	elapsed := time.Since(d.started)
+	const barWidth = 40
+	filled := 0
+	if d.total > 0 {
+		filled = d.done * barWidth / d.total
+	}
+	fmt.Fprintf(&b, "[%s%s] %d/%d repos, %d failed\n",
+		strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled), d.done, d.total, d.failed)
+	secs := max(elapsed.Seconds(), 1)
+	fmt.Fprintf(&b, "elapsed %s  %.1f repos/min  %s/s\n",
+		elapsed.Truncate(time.Second), float64(d.done)/secs*60, formatBytes(int64(float64(d.bytes)/secs)))
+	b.WriteString("\n")
+	for i, ws := range d.workers {
+		if ws.did == "" {
+			fmt.Fprintf(&b, "%3d  idle\n", i+1)
+			continue
+		}
+		fmt.Fprintf(&b, "%3d  %-40s %-11s %6d records %5d blobs  %s\n",
+			i+1, truncate(ws.did, 40), ws.phase, ws.records, ws.blobs, time.Since(ws.since).Truncate(time.Second))
+	}
+	lines := 3 + len(d.workers)
+	if len(d.errors) > 0 {
+		b.WriteString("\nrecent errors:\n")
+		for _, e := range d.errors {
+			fmt.Fprintf(&b, "  %s\n", truncate(e, 120))
+		}
+		lines += 2 + len(d.errors)
+	}
+	d.lines = lines
+	d.mu.Unlock()
+	io.WriteString(d.out, b.String())
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
+
+// formatBytes renders n with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,376 @@
+package extractor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+)
+
+// runUnpack implements the "unpack" subcommand, which extracts a single
+// local CAR file without any network access.
+func runUnpack(args []string) error {
+	var config Config
+	var outDir string
+	var toStdout bool
+
+	fs := flag.NewFlagSet("unpack", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s unpack [flags] <car-file|->\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&outDir, "o", "", "output directory (default: the repo DID); \"-\" streams NDJSON to stdout")
+	fs.BoolVar(&toStdout, "stdout", false, "stream records to stdout as NDJSON (same as -o -)")
+	fs.IntVar(&config.RecordWorkers, "record-workers", 1, "decode records with this many workers in NDJSON mode")
+	fs.BoolVar(&config.PreserveOrder, "preserve-order", false, "with -record-workers, still write NDJSON lines in MST key order")
+	addUnpackFlags(fs, &config)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := checkOutputNames(config); err != nil {
+		return err
+	}
+	carPath := fs.Arg(0)
+
+	if toStdout || outDir == "-" {
+		if ignored := streamIgnoredFlags(config); len(ignored) > 0 {
+			return fmt.Errorf("-o - writes NDJSON and can't be combined with %s", strings.Join(ignored, ", "))
+		}
+		logw = os.Stderr
+		return carUnpackStream(context.Background(), carPath, os.Stdout, config)
+	}
+	return carUnpack(carPath, outDir, config)
+}
+
+// StreamLine is one line of NDJSON output from "unpack -o -". The first line
+// has Type "commit"; every following line is a record.
+type StreamLine struct {
+	Type       string             `json:"type"`
+	Commit     *repo.SignedCommit `json:"commit,omitempty"`
+	URI        string             `json:"uri,omitempty"`
+	Collection string             `json:"collection,omitempty"`
+	Rkey       string             `json:"rkey,omitempty"`
+	CID        string             `json:"cid,omitempty"`
+	Seq        *int               `json:"seq,omitempty"`
+	Value      any                `json:"value,omitempty"`
+
+	// Deleted and DeletedIn mark records found only in an older snapshot by
+	// the history subcommand; DeletedIn is the first rev without them.
+	Deleted   bool   `json:"deleted,omitempty"`
+	DeletedIn string `json:"deleted_in,omitempty"`
+
+	// Action is the op ("create", "update" or "delete") of records read
+	// from firehose frames by the frame subcommand.
+	Action string `json:"action,omitempty"`
+}
+
+// carUnpackStream writes the commit and every record of a local CAR file to
+// w as NDJSON instead of creating files. With config.RecordWorkers above one,
+// records are decoded concurrently and, unless config.PreserveOrder is set,
+// written in the order they finish.
+func carUnpackStream(ctx context.Context, carPath string, out io.Writer, config Config) error {
+	r, err := readCar(ctx, carPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(out, writeBufferSize(config))
+	if err := writeStream(ctx, r, w, repoState{}, config); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeStream does the work of carUnpackStream for an opened repo. Records
+// go through the same selection as unpackRepo: the repo's scope,
+// -max-record-age, -filter and the record handler.
+func writeStream(ctx context.Context, r *repo.Repo, w io.Writer, st repoState, config Config) error {
+	filter, err := parseFilter(config.Filter)
+	if err != nil {
+		return err
+	}
+	redact := newRedactor(config.DropFields, config.HashFields)
+
+	enc := json.NewEncoder(w)
+	sc := r.SignedCommit()
+	if st.writesCommit(config) {
+		if err := enc.Encode(StreamLine{Type: "commit", Commit: &sc}); err != nil {
+			return err
+		}
+	}
+
+	// encodeLine renders one record's line, or nil if it is left out
+	encodeLine := func(job streamJob) ([]byte, error) {
+		blk, err := r.Blockstore().Get(ctx, job.cid)
+		if err != nil {
+			return nil, skipBadRecord(config, job.key, "get", err)
+		}
+		rec, err := decodeRecord(blk.RawData(), config.Typed)
+		if err != nil {
+			return nil, skipBadRecord(config, job.key, "decode", err)
+		}
+		if ok, err := filter.match(rec); err != nil {
+			return nil, skipBadRecord(config, job.key, "filter", err)
+		} else if !ok {
+			return nil, nil
+		}
+		value, _, err := redact.apply(rec)
+		if err != nil {
+			return nil, skipBadRecord(config, job.key, "redact", err)
+		}
+		if config.RecordHandler != nil {
+			rc := RepoContext{DID: sc.Did, Rev: sc.Rev}
+			if err := config.RecordHandler(ctx, rc, job.key, job.cid, value); errors.Is(err, ErrDropRecord) {
+				return nil, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("record handler failed on %s: %w", job.key, err)
+			}
+		}
+		if config.Canonical {
+			b, err := canonicalJSON(value)
+			if err != nil {
+				return nil, skipBadRecord(config, job.key, "marshal", err)
+			}
+			value = json.RawMessage(b)
+		}
+		collection, rkey, _ := strings.Cut(job.key, "/")
+		line := StreamLine{
+			Type:       "record",
+			URI:        "at://" + sc.Did + "/" + job.key,
+			Collection: collection,
+			Rkey:       rkey,
+			CID:        job.cid.String(),
+			Value:      value,
+		}
+		if config.SeqIndex {
+			n := job.seq
+			line.Seq = &n
+		}
+		b, err := json.Marshal(line)
+		if err != nil {
+			return nil, skipBadRecord(config, job.key, "marshal", err)
+		}
+		return append(b, '\n'), nil
+	}
+
+	// MST traversal isn't safe to share, so keys are listed up front and
+	// only block reads and decoding run in the workers
+	var jobs []streamJob
+	cutoff := recordCutoff(config)
+	seq := 0
+	err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		seq++
+		if inScope(st.scope, k) && !tooOld(k, cutoff) {
+			jobs = append(jobs, streamJob{pos: len(jobs), seq: seq - 1, key: k, cid: v})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan streamJob)
+	results := make(chan streamResult)
+	go func() {
+		defer close(queue)
+		for _, job := range jobs {
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < max(config.RecordWorkers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				line, err := encodeLine(job)
+				select {
+				case results <- streamResult{pos: job.pos, line: line, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// with PreserveOrder, lines that finish early wait in pending until
+	// every line before them has been written
+	pending := map[int]streamResult{}
+	next := 0
+	for res := range results {
+		if res.err != nil {
+			return res.err
+		}
+		if !config.PreserveOrder {
+			if _, err := w.Write(res.line); err != nil {
+				return err
+			}
+			continue
+		}
+		pending[res.pos] = res
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if _, err := w.Write(ready.line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// streamJob is one record for carUnpackStream to encode; pos is its place
+// among the records selected, seq its position in MST order among all of
+// the repo's records.
+type streamJob struct {
+	pos int
+	seq int
+	key string
+	cid cid.Cid
+}
+
+type streamResult struct {
+	pos  int
+	line []byte
+	err  error
+}
+
+// streamIgnoredFlags lists the record output options set in config that
+// have no effect when records are streamed as NDJSON, by "unpack -o -" or
+// -ordered-output, since they write files in the records directory.
+func streamIgnoredFlags(config Config) []string {
+	var out []string
+	add := func(on bool, name string) {
+		if on {
+			out = append(out, name)
+		}
+	}
+	add(config.Format != "" && config.Format != FormatJSON, "-format")
+	add(config.JSONLDContext != "", "-jsonld-context")
+	add(config.MaxOutputFileBytes > 0, "-max-output-file-bytes")
+	add(config.SinkURL != "", "-sink")
+	add(config.Fields != "", "-fields")
+	add(config.CommitFileName != "" && config.CommitFileName != defaultCommitFileName, "-commit-file-name")
+	add(config.RecordSuffix != "" && config.RecordSuffix != defaultRecordSuffix, "-record-suffix")
+	add(config.Incremental, "-incremental")
+	add(config.RecordCIDs, "-record-cids")
+	add(config.OnlyChanged, "-only-changed")
+	add(config.URIList, "-uri-list")
+	add(config.BlobRefs, "-blob-refs")
+	add(config.LocalBlobRefs, "-local-blob-refs")
+	add(config.Stats, "-stats")
+	add(config.IncludeMSTMeta, "-include-mst-meta")
+	add(config.VerifyOutput, "-verify-output")
+	return out
+}
+
+// runReunpack implements the "reunpack" subcommand, which re-extracts every
+// CAR already in a directory into the records directory without
+// re-downloading anything.
+func runReunpack(args []string) error {
+	config := Config{RecordsDir: "records"}
+
+	fs := flag.NewFlagSet("reunpack", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s reunpack [flags] <cars-dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&config.RecordsDir, "records-dir", config.RecordsDir, "directory to write records under")
+	addUnpackFlags(fs, &config)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := checkOutputNames(config); err != nil {
+		return err
+	}
+
+	carPaths, err := findCars(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(carPaths) == 0 {
+		return fmt.Errorf("no CAR files found in %s", fs.Arg(0))
+	}
+
+	ctx := context.Background()
+	failed := 0
+	for _, carPath := range carPaths {
+		if err := reunpackCar(ctx, carPath, config); err != nil {
+			fmt.Fprintf(os.Stderr, "error unpacking %s: %v\n", carPath, err)
+			failed++
+		}
+	}
+	logf("Done: %d CARs, %d failed\n", len(carPaths), failed)
+	switch {
+	case failed == 0:
+		return nil
+	case failed == len(carPaths):
+		return &exitError{code: exitAllFailed, err: fmt.Errorf("all %d CARs failed", failed)}
+	default:
+		return &exitError{code: exitPartial, err: fmt.Errorf("%d of %d CARs failed", failed, len(carPaths))}
+	}
+}
+
+// findCars lists the plain and gzipped CAR files in dir.
+func findCars(dir string) ([]string, error) {
+	var carPaths []string
+	for _, pattern := range []string{"*.car", "*.car.gz"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		carPaths = append(carPaths, matches...)
+	}
+	sort.Strings(carPaths)
+	return carPaths, nil
+}
+
+func reunpackCar(ctx context.Context, carPath string, config Config) error {
+	r, root, err := readCarRoot(ctx, carPath)
+	if err != nil {
+		return err
+	}
+	did, err := syntax.ParseDID(r.SignedCommit().Did)
+	if err != nil {
+		return err
+	}
+
+	recordsPath := filepath.Join(config.RecordsDir, did.String())
+	_, err = unpackRepo(ctx, r, root, recordsPath, repoState{}, config, nil)
+	if errors.Is(err, ErrEmptyRepo) {
+		logf("Info: %s has no records\n", did)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+)
+
+// verifyRecordFile reads a record file back after it was written and checks
+// it holds exactly the bytes meant for it and that they parse as JSON.
+func verifyRecordFile(path string, want []byte) error {
+	got, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%s: content differs from what was written", path)
+	}
+	if !json.Valid(got) {
+		return fmt.Errorf("%s: not valid JSON", path)
+	}
+	return nil
+}
+
+// verifyRecordCID re-hashes the CAR block a record was decoded from and
+// checks it still matches the record's CID.
+func verifyRecordCID(ctx context.Context, r *repo.Repo, c cid.Cid) error {
+	blk, err := r.Blockstore().Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	sum, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return fmt.Errorf("block hashes to %s, not %s", sum, c)
+	}
+	return nil
+}
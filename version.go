@@ -0,0 +1,72 @@
+package extractor
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// buildInfo describes the build of this binary, as embedded by the Go
+// toolchain.
+type buildInfo struct {
+	Version  string
+	Revision string
+	Time     string
+	Modified bool
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: "(devel)"}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if bi.Main.Version != "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// toolVersion returns a one-line version string, including the git commit
+// and build date when they are known, e.g. "v0.1.0 (a1b2c3d4e5f6, 2024-07-01T12:00:00Z)".
+func toolVersion() string {
+	info := readBuildInfo()
+	if info.Revision == "" {
+		return info.Version
+	}
+	rev := info.Revision
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if info.Modified {
+		rev += "-dirty"
+	}
+	if info.Time == "" {
+		return fmt.Sprintf("%s (%s)", info.Version, rev)
+	}
+	return fmt.Sprintf("%s (%s, %s)", info.Version, rev, info.Time)
+}
+
+func printVersion() {
+	info := readBuildInfo()
+	fmt.Printf("atproto-car-extractor %s\n", info.Version)
+	if info.Revision != "" {
+		fmt.Printf("commit: %s", info.Revision)
+		if info.Modified {
+			fmt.Printf(" (modified)")
+		}
+		fmt.Println()
+	}
+	if info.Time != "" {
+		fmt.Printf("built:  %s\n", info.Time)
+	}
+}